@@ -0,0 +1,346 @@
+package main
+
+import (
+	"github.com/calebmcelroy/wav-extract/wav"
+	"math"
+)
+
+// EBU R128 / ITU-R BS.1770 loudness
+const (
+	loudnessBlockSeconds = 0.1 // gating blocks are built from 4 of these
+	loudnessGateBlocks   = 4
+	loudnessAbsoluteGate = -70.0 // LUFS
+	loudnessRelativeGate = -10.0 // LU
+	loudnessOffset       = -0.691
+
+	// BS.1770 weight of the surround channels of a layout
+	loudnessSurroundWeight = 1.41
+
+	// frames before a file warming up the K-weighting filters & true peak history
+	analysisWarmUpSeconds = 0.5
+)
+
+// 4x oversampling interpolation filter of ITU-R BS.1770-4 annex 2, one row per phase
+var truePeakFilter = [4][12]float64{
+	{0.0017089843750, 0.0109863281250, -0.0196533203125, 0.0332031250000, -0.0594482421875, 0.1373291015625, 0.9721679687500, -0.1022949218750, 0.0476074218750, -0.0266113281250, 0.0148925781250, -0.0083007812500},
+	{-0.0291748046875, 0.0292968750000, -0.0517578125000, 0.0891113281250, -0.1665039062500, 0.4650878906250, 0.7797851562500, -0.2003173828125, 0.1015625000000, -0.0582275390625, 0.0330810546875, -0.0189208984375},
+	{-0.0189208984375, 0.0330810546875, -0.0582275390625, 0.1015625000000, -0.2003173828125, 0.7797851562500, 0.4650878906250, -0.1665039062500, 0.0891113281250, -0.0517578125000, 0.0292968750000, -0.0291748046875},
+	{-0.0083007812500, 0.0148925781250, -0.0266113281250, 0.0476074218750, -0.1022949218750, 0.9721679687500, 0.1373291015625, -0.0594482421875, 0.0332031250000, -0.0196533203125, 0.0109863281250, 0.0017089843750},
+}
+
+// channelStats are the levels of one channel of a track.
+type channelStats struct {
+	peak       float64
+	truePeak   float64
+	sum        float64
+	sumSquares float64
+	samples    int64
+	clips      int64
+}
+
+func (s *channelStats) merge(o channelStats) {
+	s.peak = max(s.peak, o.peak)
+	s.truePeak = max(s.truePeak, o.truePeak)
+	s.sum += o.sum
+	s.sumSquares += o.sumSquares
+	s.samples += o.samples
+	s.clips += o.clips
+}
+
+// loudnessBlock is the K-weighted energy of a 100ms block of a track, summed
+// over its channels.
+type loudnessBlock struct {
+	energy float64
+	frames int64
+}
+
+// TrackStats are the levels of a track, in dB.
+type TrackStats struct {
+	Peak     float64 // dBFS
+	TruePeak float64 // dBTP, only measured with TrackOptions.Report
+	RMS      float64 // dBFS
+	Loudness float64 // integrated loudness in LUFS, only measured with TrackOptions.Report
+	DCOffset float64 // largest mean of the channels, full scale is 1
+	Clips    int64   // samples at full scale
+}
+
+// biquad is a second order IIR filter.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeighting returns the BS.1770 K-weighting filters (high shelf and high pass)
+// for the sample rate.
+func kWeighting(sampleRate int) [2]biquad {
+	// high shelf
+	k := math.Tan(math.Pi * 1681.974450955533 / float64(sampleRate))
+	q := 0.7071752369554196
+	vh := math.Pow(10, 3.999843853973347/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	// high pass
+	k = math.Tan(math.Pi * 38.13547087602444 / float64(sampleRate))
+	q = 0.5003270373238773
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	return [2]biquad{shelf, highPass}
+}
+
+// analyzer measures the levels of a run of consecutive frames written to a
+// track. Files are extracted in parallel, so each file has its own analyzer
+// and the results are merged into the track.
+type analyzer struct {
+	start      int64 // track frame of the first sample
+	frame      int64 // track frame of the next sample
+	channel    int   // channel of the next sample
+	clipLevel  float64
+	sampleRate int
+	sourceRate int
+	levels     bool // peak & RMS
+	detect     bool // runs of clipping & silence
+	report     bool // true peak & loudness
+
+	channels []channelStats
+
+	// input file of the frames, for the positions of events
+	file      string
+	filePos   int64 // timeline frame of the start of the file
+	fileFrame int64 // frame of the file at start
+
+	runs   []eventRun // current run of each channel
+	events []Event
+
+	// true peak interpolation history, newest sample first
+	history [][len(truePeakFilter[0])]float64
+
+	filters     [][2]biquad
+	weights     []float64 // loudness weight of each channel
+	blockFrames int64
+	firstBlock  int64
+	blocks      []loudnessBlock
+}
+
+// newAnalyzer returns an analyzer starting at track frame frame, or nil if
+// nothing is measured.
+func newAnalyzer(frame int64, numChans int, opts TrackOptions) *analyzer {
+	if !opts.Levels && !opts.DetectEvents && !opts.Report {
+		return nil
+	}
+
+	a := &analyzer{
+		start:      frame,
+		frame:      frame,
+		sampleRate: opts.SampleRate,
+		sourceRate: opts.SourceSampleRate,
+		levels:     opts.Levels || opts.Report,
+		detect:     opts.DetectEvents,
+		report:     opts.Report,
+		channels:   make([]channelStats, numChans),
+	}
+
+	if a.detect {
+		a.runs = make([]eventRun, numChans)
+	}
+
+	// full scale of both the input and the output samples is clipped
+	a.clipLevel = min(clipLevel(opts.SourceFormat, opts.SourceBitsPerSample), clipLevel(opts.AudioFormat, opts.BitsPerSample))
+
+	if a.report {
+		a.history = make([][len(truePeakFilter[0])]float64, numChans)
+		a.filters = make([][2]biquad, numChans)
+		a.weights = make([]float64, numChans)
+		for ch := range a.filters {
+			a.filters[ch] = kWeighting(opts.SampleRate)
+			a.weights[ch] = 1
+		}
+		a.blockFrames = max(int64(loudnessBlockSeconds*float64(opts.SampleRate)), 1)
+		a.firstBlock = frame / a.blockFrames
+	}
+
+	return a
+}
+
+// clipLevel returns the level of full scale samples of a format. The largest
+// positive integer sample is one step below full scale.
+func clipLevel(format, bitsPerSample int) float64 {
+	if format == wav.FormatIEEEFloat || bitsPerSample == 0 {
+		return 1
+	}
+	return 1 - 1/float64(int64(1)<<(bitsPerSample-1))
+}
+
+// setLayout weights the loudness of the channels of a layout as in BS.1770:
+// the LFE channel is left out and the surround channels count more.
+func (a *analyzer) setLayout(layout *Layout) {
+	if !a.report || layout == nil {
+		return
+	}
+
+	for ch, label := range layout.Labels {
+		switch label {
+		case "LFE":
+			a.weights[ch] = 0
+		case "Ls", "Rs", "Lrs", "Rrs", "Lss", "Rss":
+			a.weights[ch] = loudnessSurroundWeight
+		}
+	}
+}
+
+// warmUp runs the frames just before the measured ones through the
+// K-weighting filters and the true peak history, one slice per channel.
+func (a *analyzer) warmUp(frames [][]float64) {
+	if !a.report {
+		return
+	}
+
+	for ch, samples := range frames {
+		filters := &a.filters[ch]
+		for _, v := range samples {
+			filters[1].process(filters[0].process(v))
+		}
+
+		history := &a.history[ch]
+		for _, v := range samples[max(len(samples)-len(history), 0):] {
+			copy(history[1:], history[:len(history)-1])
+			history[0] = v
+		}
+	}
+}
+
+// add measures the next sample.
+func (a *analyzer) add(v float64) {
+	if a.levels {
+		stats := &a.channels[a.channel]
+		level := math.Abs(v)
+		stats.peak = max(stats.peak, level)
+		stats.sum += v
+		stats.sumSquares += v * v
+		stats.samples++
+		if level >= a.clipLevel {
+			stats.clips++
+		}
+	}
+
+	if a.detect {
+		a.addRun(v)
+	}
+
+	if a.report {
+		a.addTruePeak(v)
+		a.addLoudness(v)
+	}
+
+	a.channel++
+	if a.channel == len(a.channels) {
+		a.channel = 0
+		a.frame++
+	}
+}
+
+func (a *analyzer) addTruePeak(v float64) {
+	history := &a.history[a.channel]
+	copy(history[1:], history[:len(history)-1])
+	history[0] = v
+
+	stats := &a.channels[a.channel]
+	for _, phase := range truePeakFilter {
+		y := 0.0
+		for i, coef := range phase {
+			y += coef * history[i]
+		}
+		stats.truePeak = max(stats.truePeak, math.Abs(y))
+	}
+	stats.truePeak = max(stats.truePeak, math.Abs(v))
+}
+
+func (a *analyzer) addLoudness(v float64) {
+	filters := &a.filters[a.channel]
+	y := filters[1].process(filters[0].process(v))
+
+	index := a.frame/a.blockFrames - a.firstBlock
+	for int64(len(a.blocks)) <= index {
+		a.blocks = append(a.blocks, loudnessBlock{})
+	}
+	a.blocks[index].energy += a.weights[a.channel] * y * y
+	if a.channel == 0 {
+		a.blocks[index].frames++
+	}
+}
+
+// integratedLoudness returns the gated loudness of the 100ms blocks of a
+// track in LUFS, or -Inf if every block is below the absolute gate.
+func integratedLoudness(blocks map[int64]loudnessBlock) float64 {
+	// gating blocks of 400ms overlapping by 75%
+	var gated []float64
+	for index := range blocks {
+		energy, frames := 0.0, int64(0)
+		for i := index; i < index+loudnessGateBlocks; i++ {
+			block, ok := blocks[i]
+			if !ok {
+				frames = 0
+				break
+			}
+			energy += block.energy
+			frames += block.frames
+		}
+		if frames == 0 {
+			continue
+		}
+
+		meanSquare := energy / float64(frames)
+		if loudnessOffset+10*math.Log10(meanSquare) > loudnessAbsoluteGate {
+			gated = append(gated, meanSquare)
+		}
+	}
+
+	relativeGate := loudnessOf(gated) + loudnessRelativeGate
+	var loud []float64
+	for _, meanSquare := range gated {
+		if loudnessOffset+10*math.Log10(meanSquare) > relativeGate {
+			loud = append(loud, meanSquare)
+		}
+	}
+
+	return loudnessOf(loud)
+}
+
+// loudnessOf returns the loudness of the mean of the block mean squares.
+func loudnessOf(meanSquares []float64) float64 {
+	if len(meanSquares) == 0 {
+		return math.Inf(-1)
+	}
+
+	sum := 0.0
+	for _, meanSquare := range meanSquares {
+		sum += meanSquare
+	}
+	return loudnessOffset + 10*math.Log10(sum/float64(len(meanSquares)))
+}
+
+// decibels converts a level, full scale is 1, to dB.
+func decibels(level float64) float64 {
+	return 20 * math.Log10(level)
+}
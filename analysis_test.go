@@ -0,0 +1,129 @@
+package main
+
+import (
+	"github.com/calebmcelroy/wav-extract/wav"
+	"math"
+	"testing"
+)
+
+// testFloatTrackOptions returns options for 32-bit float tracks of a 32-bit
+// float recording, so no dither is added.
+func testFloatTrackOptions(dir string, frames int64) TrackOptions {
+	opts := testTrackOptions(dir, frames)
+	opts.AudioFormat, opts.BitsPerSample = wav.FormatIEEEFloat, 32
+	opts.SourceFormat, opts.SourceBitsPerSample = wav.FormatIEEEFloat, 32
+	return opts
+}
+
+func TestAnalyzerStages(t *testing.T) {
+	opts := testTrackOptions("", 0)
+	if a := newAnalyzer(0, 1, opts); a != nil {
+		t.Error("analyzer created without measurements")
+	}
+
+	add := func(a *analyzer) {
+		for _, v := range []float64{0, 0.5, -1, 0.25} {
+			a.add(v)
+		}
+		a.flush()
+	}
+
+	// --skip-silent
+	opts.Levels = true
+	a := newAnalyzer(0, 1, opts)
+	add(a)
+	if a.channels[0].peak != 1 || a.channels[0].samples != 4 || a.runs != nil || a.filters != nil {
+		t.Errorf("levels: peak %g of %d samples, runs %v, filters %v", a.channels[0].peak, a.channels[0].samples, a.runs, a.filters)
+	}
+
+	// --detect-events
+	opts.Levels, opts.DetectEvents = false, true
+	a = newAnalyzer(0, 1, opts)
+	add(a)
+	if a.channels[0].samples != 0 || len(a.events) != 1 || a.filters != nil {
+		t.Errorf("events: %d samples measured, events %v", a.channels[0].samples, a.events)
+	}
+
+	// --report
+	opts.DetectEvents, opts.Report = false, true
+	a = newAnalyzer(0, 1, opts)
+	add(a)
+	if a.channels[0].samples != 4 || a.channels[0].truePeak < 1 || a.runs != nil || len(a.blocks) != 1 {
+		t.Errorf("report: %d samples, true peak %g, runs %v, %d blocks", a.channels[0].samples, a.channels[0].truePeak, a.runs, len(a.blocks))
+	}
+}
+
+// The K-weighting filters and true peak history carry on across the seams of
+// the input files, so a recording split into files measures the same as one
+// file.
+func TestAnalysisAcrossFiles(t *testing.T) {
+	const sampleRate = 48000
+
+	// a DC offset makes the K-weighting high pass ring when it restarts
+	frames := []float64{}
+	for i := range 5 * sampleRate {
+		frames = append(frames, 0.5+0.1*math.Sin(2*math.Pi*1000*float64(i)/sampleRate))
+	}
+
+	measure := func(outputRate int, lengths ...int) TrackStats {
+		opts := testFloatTrackOptions(t.TempDir(), int64(len(frames)))
+		opts.SampleRate = outputRate
+		opts.Report = true
+
+		tracks, err := initTracks("", "1", nil, 1, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		extractTestTracks(t, openTestWavs(t, sampleRate, [][]float64{frames}, lengths...), tracks)
+		return tracks[0].Stats()
+	}
+
+	var split []int
+	for range 10 {
+		split = append(split, sampleRate/2)
+	}
+
+	for _, outputRate := range []int{48000, 44100} {
+		whole := measure(outputRate, len(frames))
+		files := measure(outputRate, split...)
+
+		if math.Abs(whole.Loudness-files.Loudness) > 0.001 {
+			t.Errorf("%d Hz: loudness of one file %.4f LUFS, of 10 files %.4f LUFS", outputRate, whole.Loudness, files.Loudness)
+		}
+		if math.Abs(whole.TruePeak-files.TruePeak) > 1e-6 {
+			t.Errorf("%d Hz: true peak of one file %.6f dBTP, of 10 files %.6f dBTP", outputRate, whole.TruePeak, files.TruePeak)
+		}
+	}
+}
+
+func TestLoudnessWeights(t *testing.T) {
+	const sampleRate = 48000
+
+	// a 1 kHz tone on one channel of a 5.1 group
+	loudness := func(ch int) float64 {
+		frames := make([][]float64, 6)
+		for i := range frames {
+			frames[i] = make([]float64, 2*sampleRate)
+		}
+		for i := range frames[ch] {
+			frames[ch][i] = 0.5 * math.Sin(2*math.Pi*1000*float64(i)/sampleRate)
+		}
+
+		opts := testFloatTrackOptions(t.TempDir(), int64(len(frames[0])))
+		opts.Report = true
+		tracks, err := initTracks("", "1/2/3/4/5/6:5.1", nil, 6, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		extractTestTracks(t, openTestWavs(t, sampleRate, frames, len(frames[0])), tracks)
+		return tracks[0].Stats().Loudness
+	}
+
+	front, lfe, surround := loudness(0), loudness(3), loudness(4)
+	if !math.IsInf(lfe, -1) {
+		t.Errorf("loudness of the LFE channel = %.2f LUFS, want it left out", lfe)
+	}
+	if diff := surround - front; math.Abs(diff-10*math.Log10(loudnessSurroundWeight)) > 0.01 {
+		t.Errorf("surround channel is %.2f LU louder than the front, want +1.49 LU", diff)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/calebmcelroy/wav-extract/wav"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// peak level of normalized buses
+	normalizeLevel = -1.0 // dBFS
+
+	// the limiter leaves levels below the knee untouched and keeps peaks
+	// below the ceiling
+	limiterKnee    = 0.7   // about -3 dBFS
+	limiterCeiling = 0.989 // about -0.1 dBFS
+)
+
+// Bus is a track mixed from several channels, e.g. Drums=1,2,3:-3dB,5/6:stereo.
+type Bus struct {
+	Name      string
+	Stereo    bool
+	Limit     bool // soft limit peaks of the mix
+	Normalize bool // scale the mix to a peak of normalizeLevel
+	Inputs    []BusInput
+}
+
+// BusInput is a mono channel or stereo pair of a bus.
+type BusInput struct {
+	Channels []int   // zero-based
+	Gain     float64 // linear
+	Pan      float64 // -1 (left) to 1 (right), balance for stereo pairs
+}
+
+// busFlag collects the values of --bus, which can be repeated.
+type busFlag []string
+
+func (f *busFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *busFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// parseBus parses a bus given as name=channels, e.g.
+// Drums=1,2,3:-6dB,4:L30,5/6:stereo, with the bus options last.
+func parseBus(str string, numChans int) (Bus, error) {
+	name, inputsStr, ok := strings.Cut(str, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.TrimSpace(inputsStr) == "" {
+		return Bus{}, fmt.Errorf("invalid bus %s, expected name=channels (e.g. Drums=1,2,3,4:stereo)", str)
+	}
+
+	bus := Bus{Name: name}
+	panned := "" // the first pan, a mono bus has nothing to pan to
+	items := strings.Split(inputsStr, ",")
+	for i, item := range items {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+
+		input := BusInput{Gain: 1}
+		for j, channelStr := range strings.Split(parts[0], "/") {
+			ch, err := strconv.Atoi(strings.TrimSpace(channelStr))
+			if err != nil || ch < 1 || ch > numChans || j > 1 {
+				return Bus{}, fmt.Errorf("invalid channel %s in bus %s, channels must be between 1 and %d or a pair like 5/6", parts[0], name, numChans)
+			}
+			input.Channels = append(input.Channels, ch-1)
+		}
+
+		for _, modifier := range parts[1:] {
+			modifier = strings.ToLower(strings.TrimSpace(modifier))
+			switch {
+			case modifier == "stereo" || modifier == "mono" || modifier == "limit" || modifier == "normalize":
+				if i != len(items)-1 {
+					return Bus{}, fmt.Errorf("bus option %s must come after the last channel of bus %s", modifier, name)
+				}
+				bus.Stereo = bus.Stereo || modifier == "stereo"
+				bus.Limit = bus.Limit || modifier == "limit"
+				bus.Normalize = bus.Normalize || modifier == "normalize"
+			case strings.HasSuffix(modifier, "db"):
+				db, err := strconv.ParseFloat(strings.TrimSuffix(modifier, "db"), 64)
+				if err != nil {
+					return Bus{}, fmt.Errorf("invalid gain %s in bus %s", modifier, name)
+				}
+				input.Gain = math.Pow(10, db/20)
+			case modifier == "c":
+				input.Pan = 0
+				panned = cmp.Or(panned, parts[0]+":"+modifier)
+			case strings.HasPrefix(modifier, "l") || strings.HasPrefix(modifier, "r"):
+				pan, err := strconv.ParseFloat(modifier[1:], 64)
+				if err != nil || pan < 0 || pan > 100 {
+					return Bus{}, fmt.Errorf("invalid pan %s in bus %s, expected L0-L100, C or R0-R100", modifier, name)
+				}
+				input.Pan = pan / 100
+				if modifier[0] == 'l' {
+					input.Pan = -input.Pan
+				}
+				panned = cmp.Or(panned, parts[0]+":"+modifier)
+			default:
+				return Bus{}, fmt.Errorf("invalid modifier %s in bus %s", modifier, name)
+			}
+		}
+
+		bus.Inputs = append(bus.Inputs, input)
+	}
+
+	if bus.Limit && bus.Normalize {
+		return Bus{}, fmt.Errorf("bus %s cannot be both limited and normalized", name)
+	}
+
+	if panned != "" && !bus.Stereo {
+		return Bus{}, fmt.Errorf("pan %s of mono bus %s has no effect, add :stereo after the last channel", panned, name)
+	}
+
+	return bus, nil
+}
+
+// matrix returns the input channels of the bus and the gain of each of them
+// in each output channel.
+func (b Bus) matrix() ([]int, [][]float64) {
+	var channels []int
+	for _, input := range b.Inputs {
+		for _, ch := range input.Channels {
+			if !slices.Contains(channels, ch) {
+				channels = append(channels, ch)
+			}
+		}
+	}
+
+	numChans := 1
+	if b.Stereo {
+		numChans = 2
+	}
+	matrix := make([][]float64, numChans)
+	for out := range matrix {
+		matrix[out] = make([]float64, len(channels))
+	}
+
+	for _, input := range b.Inputs {
+		left := slices.Index(channels, input.Channels[0])
+		right := left
+		if len(input.Channels) == 2 {
+			right = slices.Index(channels, input.Channels[1])
+		}
+
+		switch {
+		case !b.Stereo && len(input.Channels) == 2:
+			matrix[0][left] += input.Gain / 2
+			matrix[0][right] += input.Gain / 2
+		case !b.Stereo:
+			matrix[0][left] += input.Gain
+		case len(input.Channels) == 2:
+			// balance turns down one side
+			matrix[0][left] += input.Gain * min(1, 1-input.Pan)
+			matrix[1][right] += input.Gain * min(1, 1+input.Pan)
+		default:
+			// constant power pan law, -3 dB in the center
+			angle := (input.Pan + 1) * math.Pi / 4
+			matrix[0][left] += input.Gain * math.Cos(angle)
+			matrix[1][left] += input.Gain * math.Sin(angle)
+		}
+	}
+
+	return channels, matrix
+}
+
+func newBusTrack(index int, bus Bus, opts TrackOptions) (*Track, error) {
+	channels, matrix := bus.matrix()
+
+	// normalized buses are mixed to float so peaks above full scale are kept,
+	// their peak is measured for normalizeTrack
+	finalFormat, finalBits := opts.AudioFormat, opts.BitsPerSample
+	if bus.Normalize {
+		opts.AudioFormat, opts.BitsPerSample = wav.FormatIEEEFloat, 32
+		opts.Levels = true
+	}
+
+	name := sanitizeFileName(bus.Name)
+	track, err := newTrackNamed(index, channels, len(matrix), name, bus.Name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	track.Matrix = matrix
+	track.limit = bus.Limit
+	if bus.Normalize {
+		track.finalFormat, track.finalBits = finalFormat, finalBits
+	}
+
+	return track, nil
+}
+
+// mix returns the output channels of the track mixed from frames of its
+// input channels, or the frames themselves for tracks without a mix.
+func (t *Track) mix(frames [][]float64) [][]float64 {
+	if t.Matrix == nil {
+		return frames
+	}
+
+	mixed := make([][]float64, len(t.Matrix))
+	for out, gains := range t.Matrix {
+		mixed[out] = make([]float64, len(frames[0]))
+		for in, gain := range gains {
+			if gain == 0 {
+				continue
+			}
+			for i, v := range frames[in] {
+				mixed[out][i] += gain * v
+			}
+		}
+	}
+
+	return mixed
+}
+
+// inputChannel returns the input channel output channel ch is made from, or
+// -1 if it is mixed from several channels.
+func (t *Track) inputChannel(ch int) int {
+	if t.Matrix == nil {
+		return t.Channels[ch]
+	}
+
+	channel := -1
+	for in, gain := range t.Matrix[ch] {
+		if gain == 0 {
+			continue
+		}
+		if channel >= 0 {
+			return -1
+		}
+		channel = t.Channels[in]
+	}
+	return channel
+}
+
+// limitSample softly limits peaks above the knee to below the ceiling.
+func limitSample(v float64) float64 {
+	level := math.Abs(v)
+	if level <= limiterKnee {
+		return v
+	}
+
+	limited := limiterKnee + (limiterCeiling-limiterKnee)*math.Tanh((level-limiterKnee)/(limiterCeiling-limiterKnee))
+	return math.Copysign(limited, v)
+}
+
+// normalizeTrack scales a bus mixed to float to a peak of normalizeLevel and
+// converts it to its final format.
+func (t *Track) normalizeTrack() error {
+	if t.finalFormat == 0 {
+		return nil
+	}
+
+	gain := 1.0
+	if peak := t.Peak(); !math.IsInf(peak, -1) {
+		gain = math.Pow(10, (normalizeLevel-peak)/20)
+	}
+
+	final := t.opts
+	final.AudioFormat, final.BitsPerSample = t.finalFormat, t.finalBits
+
+	// one converter for all segments, so the dither runs on across them
+	c, err := newConverter(t.opts.AudioFormat, t.opts.BitsPerSample, final.AudioFormat, final.BitsPerSample, final.Dither, t.numChans())
+	if err != nil {
+		return err
+	}
+	c.seed(t.Index, 0)
+
+	for _, segment := range t.segments {
+		if segment == nil {
+			continue
+		}
+
+		if err := segment.writer.Close(); err != nil {
+			return err
+		}
+		if err := segment.file.Close(); err != nil {
+			return err
+		}
+
+		path := segment.file.Name()
+		tmpPath := path + ".tmp"
+		if err := os.Rename(path, tmpPath); err != nil {
+			return err
+		}
+
+		writer, file, err := t.convertFile(tmpPath, path, gain, c, final)
+		if err != nil {
+			return err
+		}
+		segment.writer, segment.file = writer, file
+
+		if err := os.Remove(tmpPath); err != nil {
+			return err
+		}
+	}
+
+	t.opts = final
+	t.decode, _ = wav.Decoder(final.AudioFormat, final.BitsPerSample)
+	t.finalFormat, t.finalBits = 0, 0
+	t.scaleStats(gain)
+
+	return nil
+}
+
+// scaleStats updates the levels measured while extracting after the track
+// is scaled by gain.
+func (t *Track) scaleStats(gain float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for ch := range t.stats {
+		stats := &t.stats[ch]
+		stats.peak *= gain
+		stats.truePeak *= gain
+		stats.sum *= gain
+		stats.sumSquares *= gain * gain
+		stats.clips = 0
+	}
+
+	for index, block := range t.blocks {
+		block.energy *= gain * gain
+		t.blocks[index] = block
+	}
+
+	// peaks are below full scale after normalizing
+	var events []Event
+	for _, event := range t.events {
+		if event.Kind != eventClip {
+			events = append(events, event)
+		}
+	}
+	t.events = events
+}
+
+// convertFile writes the frames of the wav file at inPath, scaled by gain and
+// converted by c, to a new file of the track at outPath in the format of opts
+// and returns it open so chunks can be added.
+func (t *Track) convertFile(inPath, outPath string, gain float64, c *converter, opts TrackOptions) (*wav.Writer, *os.File, error) {
+	numChans := t.numChans()
+
+	in, err := os.Open(inPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer in.Close()
+
+	r := wav.NewReader(in)
+	if err := r.ReadHeader(); err != nil {
+		return nil, nil, fmt.Errorf("invalid WAV file (%s): %w", inPath, err)
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create output file '%s': %v", outPath, err)
+	}
+
+	w := t.newWriter(out, opts)
+
+	frames := make([][]float64, numChans)
+	for ch := range frames {
+		frames[ch] = make([]float64, opts.SampleRate)
+	}
+	blockAlign := numChans * opts.BitsPerSample / 8
+	buf := make([]byte, len(frames[0])*blockAlign)
+
+	off := int64(0)
+	for {
+		n, err := r.ReadFrames(frames)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			out.Close()
+			return nil, nil, fmt.Errorf("failed to read %s: %v", inPath, err)
+		}
+
+		for i := range n {
+			for ch := range frames {
+				c.encodeSample(buf[i*blockAlign+ch*opts.BitsPerSample/8:], frames[ch][i]*gain, ch)
+			}
+		}
+
+		if _, werr := w.WriteAt(buf[:n*blockAlign], off); werr != nil {
+			out.Close()
+			return nil, nil, werr
+		}
+		off += int64(n * blockAlign)
+	}
+
+	return w, out, nil
+}
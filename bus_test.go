@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"github.com/calebmcelroy/wav-extract/wav"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseBus(t *testing.T) {
+	half := math.Pow(10, -6.0/20)
+
+	tests := []struct {
+		str string
+		bus Bus
+	}{
+		{"Drums=1,2", Bus{Name: "Drums", Inputs: []BusInput{{[]int{0}, 1, 0}, {[]int{1}, 1, 0}}}},
+		{" Keys = 3/4:-6dB ", Bus{Name: "Keys", Inputs: []BusInput{{[]int{2, 3}, half, 0}}}},
+		{"Mix=1:L30,2:c,3:R100:-6db,4/5:L50:stereo:limit", Bus{Name: "Mix", Stereo: true, Limit: true, Inputs: []BusInput{
+			{[]int{0}, 1, -0.3},
+			{[]int{1}, 1, 0},
+			{[]int{2}, half, 1},
+			{[]int{3, 4}, 1, -0.5},
+		}}},
+		{"Vox=9,10:mono:normalize", Bus{Name: "Vox", Normalize: true, Inputs: []BusInput{{[]int{8}, 1, 0}, {[]int{9}, 1, 0}}}},
+	}
+
+	for _, test := range tests {
+		bus, err := parseBus(test.str, 16)
+		if err != nil {
+			t.Errorf("parseBus(%q): %v", test.str, err)
+			continue
+		}
+		if !reflect.DeepEqual(bus, test.bus) {
+			t.Errorf("parseBus(%q) = %+v, want %+v", test.str, bus, test.bus)
+		}
+	}
+
+	for _, str := range []string{
+		"Drums",
+		"=1,2",
+		"Drums=",
+		"Drums=0,1",
+		"Drums=17",
+		"Drums=1/2/3",
+		"Drums=1:stereo,2",
+		"Drums=1,2:limit:normalize",
+		"Drums=1:loud",
+		"Drums=1:xdB",
+		"Drums=1:L120:stereo",
+		"Drums=1:L30,2",
+		"Drums=1:C,2:mono",
+	} {
+		if bus, err := parseBus(str, 16); err == nil {
+			t.Errorf("parseBus(%q) = %+v, want an error", str, bus)
+		}
+	}
+}
+
+func TestBusMatrix(t *testing.T) {
+	// a mono bus sums pairs at half gain
+	bus, _ := parseBus("Mono=3,1/2,3:-6dB", 4)
+	channels, matrix := bus.matrix()
+	half := math.Pow(10, -6.0/20)
+	if !reflect.DeepEqual(channels, []int{2, 0, 1}) {
+		t.Errorf("channels = %v, want [2 0 1]", channels)
+	}
+	if want := [][]float64{{1 + half, 0.5, 0.5}}; !matrixEqual(matrix, want) {
+		t.Errorf("mono matrix = %v, want %v", matrix, want)
+	}
+
+	// constant power pan of mono channels and balance of pairs
+	bus, _ = parseBus("Stereo=1:L100,2,3:R50,4/5:L50,6/7:stereo", 8)
+	_, matrix = bus.matrix()
+	center := math.Sqrt(0.5)
+	want := [][]float64{
+		{1, center, math.Cos(0.75 * math.Pi / 2), 1, 0, 1, 0},
+		{0, center, math.Sin(0.75 * math.Pi / 2), 0, 0.5, 0, 1},
+	}
+	if !matrixEqual(matrix, want) {
+		t.Errorf("stereo matrix = %v, want %v", matrix, want)
+	}
+}
+
+// matrixEqual returns whether two matrices are equal up to rounding.
+func matrixEqual(a, b [][]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if math.Abs(a[i][j]-b[i][j]) > 1e-12 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestLimitSample(t *testing.T) {
+	for _, v := range []float64{0, 0.1, -0.5, limiterKnee, -limiterKnee} {
+		if got := limitSample(v); got != v {
+			t.Errorf("limitSample(%g) = %g, below the knee it is unchanged", v, got)
+		}
+	}
+
+	last := limiterKnee
+	for v := limiterKnee + 0.01; v < 10; v += 0.01 {
+		got := limitSample(v)
+		if got < last || got >= limiterCeiling {
+			t.Fatalf("limitSample(%g) = %g, want rising up to the ceiling %g", v, got, limiterCeiling)
+		}
+		if limitSample(-v) != -got {
+			t.Fatalf("limitSample(%g) = %g, want %g", -v, limitSample(-v), -got)
+		}
+		last = got
+	}
+}
+
+func TestNormalizeTrack(t *testing.T) {
+	const sampleRate = 48000
+
+	// two channels summing above full scale
+	frames := make([][]float64, 2)
+	for ch := range frames {
+		frames[ch] = make([]float64, sampleRate)
+		for i := range frames[ch] {
+			frames[ch][i] = 0.8 * math.Sin(2*math.Pi*440*float64(i)/sampleRate)
+		}
+	}
+
+	dir := t.TempDir()
+	opts := testFloatTrackOptions(dir, sampleRate)
+	opts.AudioFormat, opts.BitsPerSample = wav.FormatPCM, 16
+	opts.SegmentFrames = 20000
+	bus, err := parseBus("Mix=1,2:normalize", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks, err := initTracks("", "1", []Bus{bus}, 2, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mix := tracks[1]
+
+	wavFiles := openTestWavs(t, sampleRate, frames, sampleRate)
+	extract(context.Background(), wavFiles, tracks, 0, sampleRate, time.Millisecond, func(Progress) {})
+
+	// mixed to float, peaks above full scale are kept
+	if peak := mix.Peak(); math.Abs(peak-decibels(1.6)) > 0.01 {
+		t.Errorf("peak of the mix = %.2f dBFS, want %.2f", peak, decibels(1.6))
+	}
+
+	for _, track := range tracks {
+		if err := track.normalizeTrack(); err != nil {
+			t.Fatal(err)
+		}
+		if err := track.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if peak := mix.Peak(); math.Abs(peak-normalizeLevel) > 0.01 {
+		t.Errorf("peak after normalizing = %.2f dBFS, want %g", peak, normalizeLevel)
+	}
+
+	// the segments are converted to 16-bit and scaled by the same gain
+	gain := math.Pow(10, normalizeLevel/20) / 1.6
+	if len(mix.segments) != 3 {
+		t.Fatalf("%d segments, want 3", len(mix.segments))
+	}
+	for i, segment := range mix.segments {
+		out, _ := readTestWav(t, filepath.Join(dir, segment.Name))
+		if _, err := os.Stat(filepath.Join(dir, segment.Name+".tmp")); !os.IsNotExist(err) {
+			t.Errorf("%s.tmp was not removed", segment.Name)
+		}
+
+		maxError := 0.0
+		for j, v := range out[0] {
+			frame := i*int(opts.SegmentFrames) + j
+			maxError = max(maxError, math.Abs(v-(frames[0][frame]+frames[1][frame])*gain))
+		}
+		if maxError > 2.0/(1<<15) {
+			t.Errorf("%s differs from the scaled mix by %g", segment.Name, maxError)
+		}
+	}
+}
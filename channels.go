@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TrackSpec is a track of --channels or --stereo with its processing, e.g.
+// 3/4:ms, 5:-6dB:invert or 9/10/11/12/13/14:5.1.
+type TrackSpec struct {
+	Channels []int   // zero-based
+	Gain     float64 // linear
+	Invert   bool    // flip the polarity
+	MidSide  bool    // decode mid (left) & side (right) to left & right
+	Layout   *Layout // speaker layout of a group, nil for plain channels
+}
+
+// matrix returns the gains of the channels in each output channel, or nil
+// if the channels are written as they are.
+func (s TrackSpec) matrix() [][]float64 {
+	gain := s.Gain
+	if s.Invert {
+		gain = -gain
+	}
+	if gain == 1 && !s.MidSide {
+		return nil
+	}
+
+	if s.MidSide {
+		// left = mid + side, right = mid - side
+		return [][]float64{{gain, gain}, {gain, -gain}}
+	}
+
+	matrix := make([][]float64, len(s.Channels))
+	for out := range matrix {
+		matrix[out] = make([]float64, len(s.Channels))
+		matrix[out][out] = gain
+	}
+	return matrix
+}
+
+// ChannelSpecError is an error in a channel specification with the position
+// it was found at.
+type ChannelSpecError struct {
+	Spec string
+	Pos  int // zero-based byte offset
+	Msg  string
+}
+
+func (e *ChannelSpecError) Error() string {
+	return fmt.Sprintf("%s (at position %d)\n  %s\n  %s^", e.Msg, e.Pos+1, e.Spec, strings.Repeat(" ", e.Pos))
+}
+
+// specField is a part of a channel specification and its position.
+type specField struct {
+	text string
+	pos  int
+}
+
+// channelParser parses the grammar of --channels and --stereo:
+//
+//	spec      = item { "," item }
+//	item      = "!" channels | channels { ":" modifier }
+//	channels  = "all" | range { "/" range }
+//	range     = number [ "-" number ]
+//	modifier  = layout | "pairs" | "ms" | "invert" | gain
+//
+// A single range is a mono track per channel, channels joined by "/" or
+// given a layout are one track, and pairs makes stereo pairs of a range.
+// Tracks using a channel excluded with "!" are left out.
+type channelParser struct {
+	spec     string
+	numChans int
+}
+
+func (p *channelParser) errorf(pos int, format string, args ...any) error {
+	return &ChannelSpecError{Spec: p.spec, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// split splits f at sep into fields trimmed of spaces.
+func (p *channelParser) split(f specField, sep string) []specField {
+	var fields []specField
+	pos := f.pos
+	for _, text := range strings.Split(f.text, sep) {
+		trimmed := strings.TrimLeft(text, " ")
+		fields = append(fields, specField{strings.TrimRight(trimmed, " "), pos + len(text) - len(trimmed)})
+		pos += len(text) + len(sep)
+	}
+	return fields
+}
+
+// number parses a one-based channel number into a zero-based channel.
+func (p *channelParser) number(f specField) (int, error) {
+	ch, err := strconv.Atoi(f.text)
+	if err != nil || strings.HasPrefix(f.text, "+") {
+		return 0, p.errorf(f.pos, "expected a channel number, got %q", f.text)
+	}
+	if ch < 1 || ch > p.numChans {
+		return 0, p.errorf(f.pos, "channel %d is out of range, channels must be between 1 and %d", ch, p.numChans)
+	}
+	return ch - 1, nil
+}
+
+// channels parses the channels of an item. It returns whether they are a
+// group joined by "/".
+func (p *channelParser) channels(f specField) (channels []int, group bool, err error) {
+	if f.text == "" {
+		return nil, false, p.errorf(f.pos, "expected channels")
+	}
+
+	if strings.EqualFold(f.text, "all") {
+		for ch := range p.numChans {
+			channels = append(channels, ch)
+		}
+		return channels, false, nil
+	}
+
+	ranges := p.split(f, "/")
+	for _, r := range ranges {
+		bounds := p.split(r, "-")
+		if len(bounds) > 2 {
+			return nil, false, p.errorf(r.pos, "invalid range %s, expected first-last", r.text)
+		}
+		first, last := bounds[0], bounds[len(bounds)-1]
+
+		from, err := p.number(first)
+		if err != nil {
+			return nil, false, err
+		}
+		to, err := p.number(last)
+		if err != nil {
+			return nil, false, err
+		}
+		if to < from {
+			return nil, false, p.errorf(r.pos, "range %s must go from the lower to the higher channel", r.text)
+		}
+
+		for ch := from; ch <= to; ch++ {
+			if slices.Contains(channels, ch) {
+				return nil, false, p.errorf(r.pos, "channel %d is used twice in the group", ch+1)
+			}
+			channels = append(channels, ch)
+		}
+	}
+
+	return channels, len(ranges) > 1, nil
+}
+
+// item parses an item with its modifiers into tracks.
+func (p *channelParser) item(item specField, allowMono bool) ([]TrackSpec, error) {
+	parts := p.split(item, ":")
+	channels, group, err := p.channels(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	spec := TrackSpec{Gain: 1}
+	pairs := false
+	var layout *specField
+	var midSide *specField
+	for i, modifier := range parts[1:] {
+		name := strings.ToLower(modifier.text)
+		switch {
+		case isLayout(name):
+			layout = &parts[1+i]
+		case name == "pairs":
+			pairs = true
+		case name == "ms":
+			midSide = &parts[1+i]
+		case name == "invert":
+			spec.Invert = true
+		case strings.HasSuffix(name, "db"):
+			db, err := strconv.ParseFloat(strings.TrimSuffix(name, "db"), 64)
+			if err != nil {
+				return nil, p.errorf(modifier.pos, "invalid gain %s", modifier.text)
+			}
+			spec.Gain = math.Pow(10, db/20)
+		default:
+			return nil, p.errorf(modifier.pos, "unknown modifier %q, expected a layout (lcr, quad, 5.0, 5.1, 7.1, ambix), pairs, ms, invert or a gain like -6dB", modifier.text)
+		}
+	}
+
+	// the tracks made from the channels
+	var groups [][]int
+	switch {
+	case layout != nil && pairs:
+		return nil, p.errorf(layout.pos, "a layout cannot be combined with pairs")
+	case layout != nil:
+		spec.Layout, err = getLayout(strings.ToLower(layout.text), len(channels))
+		if err != nil {
+			return nil, p.errorf(layout.pos, "%v", err)
+		}
+		groups = [][]int{channels}
+	case pairs:
+		if group || len(channels)%2 != 0 {
+			return nil, p.errorf(parts[0].pos, "pairs needs a range of an even number of channels, e.g. 17-24:pairs")
+		}
+		for i := 0; i < len(channels); i += 2 {
+			groups = append(groups, channels[i:i+2])
+		}
+	case group:
+		groups = [][]int{channels}
+	default:
+		for _, ch := range channels {
+			groups = append(groups, []int{ch})
+		}
+	}
+
+	var specs []TrackSpec
+	for _, channels := range groups {
+		spec := spec
+		spec.Channels = channels
+		if midSide != nil {
+			if len(channels) != 2 || spec.Layout != nil {
+				return nil, p.errorf(midSide.pos, "mid/side needs stereo pairs")
+			}
+			spec.MidSide = true
+		}
+
+		// other channels are extracted as mono tracks with --stereo
+		if len(channels) == 1 && !allowMono && spec.matrix() == nil {
+			return nil, p.errorf(item.pos, "expected a stereo pair or group, channels not listed are extracted as mono with --stereo")
+		}
+
+		specs = append(specs, spec)
+	}
+
+	return specs, nil
+}
+
+// parseChannelsString parses a --channels or --stereo specification, see
+// channelParser, into tracks and the zero-based channels excluded with "!".
+// Without allowMono only groups and processed mono channels are allowed.
+func parseChannelsString(str string, numChans int, allowMono bool) ([]TrackSpec, []int, error) {
+	p := &channelParser{spec: str, numChans: numChans}
+
+	var specs []TrackSpec
+	var excluded []int
+	for _, item := range p.split(specField{str, 0}, ",") {
+		if rest, ok := strings.CutPrefix(item.text, "!"); ok {
+			parts := p.split(specField{rest, item.pos + 1}, ":")
+			if len(parts) > 1 {
+				return nil, nil, p.errorf(parts[1].pos, "excluded channels cannot have modifiers")
+			}
+
+			channels, group, err := p.channels(parts[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			if group {
+				return nil, nil, p.errorf(parts[0].pos, "expected a channel or range to exclude, e.g. !25-32")
+			}
+			excluded = append(excluded, channels...)
+			continue
+		}
+
+		itemSpecs, err := p.item(item, allowMono)
+		if err != nil {
+			return nil, nil, err
+		}
+		specs = append(specs, itemSpecs...)
+	}
+
+	specs = slices.DeleteFunc(specs, func(spec TrackSpec) bool {
+		return slices.ContainsFunc(spec.Channels, func(ch int) bool {
+			return slices.Contains(excluded, ch)
+		})
+	})
+
+	if allowMono && len(specs) == 0 {
+		return nil, nil, fmt.Errorf("no channels left to extract in %s", str)
+	}
+
+	return specs, excluded, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseChannelsString(t *testing.T) {
+	tests := []struct {
+		str       string
+		allowMono bool
+		tracks    [][]int // one-based
+		excluded  []int   // one-based
+	}{
+		{"1/2,5", true, [][]int{{1, 2}, {5}}, nil},
+		{"1-4", true, [][]int{{1}, {2}, {3}, {4}}, nil},
+		{"5-8:pairs", true, [][]int{{5, 6}, {7, 8}}, nil},
+		{"all,!3-8", true, [][]int{{1}, {2}}, []int{3, 4, 5, 6, 7, 8}},
+		{"1-4:pairs,!2", true, [][]int{{3, 4}}, []int{2}},
+		{"1-6:5.1", true, [][]int{{1, 2, 3, 4, 5, 6}}, nil},
+		{"1/2/3", true, [][]int{{1, 2, 3}}, nil},
+		{"1-2/7", true, [][]int{{1, 2, 7}}, nil},
+		{"3,3/4,3:-6dB", true, [][]int{{3}, {3, 4}, {3}}, nil},
+		{" 1 / 2 , 3 - 4 ", true, [][]int{{1, 2}, {3}, {4}}, nil},
+		{"1/2,!7-8", false, [][]int{{1, 2}}, []int{7, 8}},
+		{"5:invert,1-4:pairs:ms", false, [][]int{{5}, {1, 2}, {3, 4}}, nil},
+	}
+
+	for _, test := range tests {
+		specs, excluded, err := parseChannelsString(test.str, 8, test.allowMono)
+		if err != nil {
+			t.Errorf("parseChannelsString(%q): %v", test.str, err)
+			continue
+		}
+
+		var tracks [][]int
+		for _, spec := range specs {
+			channels := make([]int, len(spec.Channels))
+			for i, ch := range spec.Channels {
+				channels[i] = ch + 1
+			}
+			tracks = append(tracks, channels)
+		}
+		if !reflect.DeepEqual(tracks, test.tracks) {
+			t.Errorf("parseChannelsString(%q) tracks = %v, want %v", test.str, tracks, test.tracks)
+		}
+
+		var excludedChannels []int
+		for _, ch := range excluded {
+			excludedChannels = append(excludedChannels, ch+1)
+		}
+		if !reflect.DeepEqual(excludedChannels, test.excluded) {
+			t.Errorf("parseChannelsString(%q) excluded = %v, want %v", test.str, excludedChannels, test.excluded)
+		}
+	}
+}
+
+func TestParseChannelsStringModifiers(t *testing.T) {
+	specs, _, err := parseChannelsString("1/2:ms:-6dB,3:invert,5-8:ambix", 8, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !specs[0].MidSide || math.Abs(specs[0].Gain-0.501) > 0.001 {
+		t.Errorf("1/2:ms:-6dB = %+v", specs[0])
+	}
+	if !specs[1].Invert || specs[1].Gain != 1 {
+		t.Errorf("3:invert = %+v", specs[1])
+	}
+	if specs[2].Layout == nil || specs[2].Layout.Name != "ambix" || specs[2].Layout.Labels[3] != "X" {
+		t.Errorf("5-8:ambix layout = %+v", specs[2].Layout)
+	}
+
+	// left = mid + side, right = mid - side
+	if matrix := specs[0].matrix(); matrix[0][1] <= 0 || matrix[1][1] >= 0 {
+		t.Errorf("mid/side matrix = %v", matrix)
+	}
+	if matrix := specs[1].matrix(); matrix[0][0] != -1 {
+		t.Errorf("invert matrix = %v", matrix)
+	}
+	if matrix := specs[2].matrix(); matrix != nil {
+		t.Errorf("unprocessed group matrix = %v, want nil", matrix)
+	}
+}
+
+func TestTrackSpecMatrix(t *testing.T) {
+	half := math.Pow(10, -6.0/20)
+
+	tests := []struct {
+		str    string
+		matrix [][]float64
+	}{
+		{"1", nil},
+		{"1/2", nil},
+		{"1:0dB", nil},
+		{"1:-6dB", [][]float64{{half}}},
+		{"1/2:+6dB", [][]float64{{1 / half, 0}, {0, 1 / half}}},
+		{"1/2:invert", [][]float64{{-1, 0}, {0, -1}}},
+		{"1:invert:-6dB", [][]float64{{-half}}},
+		{"1/2:ms", [][]float64{{1, 1}, {1, -1}}},
+		{"1/2:ms:-6dB", [][]float64{{half, half}, {half, -half}}},
+		{"1/2:ms:invert", [][]float64{{-1, -1}, {-1, 1}}},
+	}
+
+	for _, test := range tests {
+		specs, _, err := parseChannelsString(test.str, 2, true)
+		if err != nil {
+			t.Errorf("parseChannelsString(%q): %v", test.str, err)
+			continue
+		}
+		if matrix := specs[0].matrix(); !matrixEqual(matrix, test.matrix) {
+			t.Errorf("%s matrix = %v, want %v", test.str, matrix, test.matrix)
+		}
+	}
+}
+
+func TestTrackModifiers(t *testing.T) {
+	const sampleRate = 48000
+
+	// mid, side and a mono channel
+	frames := [][]float64{make([]float64, 1000), make([]float64, 1000), make([]float64, 1000)}
+	for i := range frames[0] {
+		frames[0][i] = 0.4 * math.Sin(float64(i)/10)
+		frames[1][i] = 0.1 * math.Cos(float64(i)/7)
+		frames[2][i] = 0.5 * math.Sin(float64(i)/5)
+	}
+
+	opts := testFloatTrackOptions(t.TempDir(), 1000)
+	tracks, err := initTracks("", "1/2:ms,3:invert:-6dB", nil, 3, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractTestTracks(t, openTestWavs(t, sampleRate, frames, 1000), tracks)
+
+	stereo, _ := readTestWav(t, filepath.Join(opts.OutputDir, "track_1L_2R.wav"))
+	mono, _ := readTestWav(t, filepath.Join(opts.OutputDir, "track_3.wav"))
+	gain := math.Pow(10, -6.0/20)
+	for i := range frames[0] {
+		left, right := frames[0][i]+frames[1][i], frames[0][i]-frames[1][i]
+		if math.Abs(stereo[0][i]-left) > 1e-6 || math.Abs(stereo[1][i]-right) > 1e-6 {
+			t.Fatalf("frame %d of the mid/side track = %g, %g, want %g, %g", i, stereo[0][i], stereo[1][i], left, right)
+		}
+		if want := -gain * frames[2][i]; math.Abs(mono[0][i]-want) > 1e-6 {
+			t.Fatalf("frame %d of the inverted track = %g, want %g", i, mono[0][i], want)
+		}
+	}
+}
+
+func TestParseChannelsStringErrors(t *testing.T) {
+	tests := []struct {
+		str       string
+		allowMono bool
+		pos       int // zero-based
+	}{
+		{"1/2,x", true, 4},
+		{"1,,2", true, 2},
+		{"1-9", true, 2},
+		{"0", true, 0},
+		{"4-1", true, 0},
+		{"1-2-3", true, 0},
+		{"1/2/1", true, 4},
+		{"1-3:pairs", true, 0},
+		{"1/2/3/4:pairs", true, 0},
+		{"1/2:foo", true, 4},
+		{"1/2:1.5.0dB", true, 4},
+		{"1-3:5.1", true, 4},
+		{"1-4:quad:pairs", true, 4},
+		{"1/2/3:ms", true, 6},
+		{"1,!2:ms", true, 5},
+		{"1,!2/3", true, 3},
+		{"1/2, 3", false, 5},
+		{"1/2,  +3", true, 6},
+	}
+
+	for _, test := range tests {
+		_, _, err := parseChannelsString(test.str, 8, test.allowMono)
+		var specErr *ChannelSpecError
+		if !errors.As(err, &specErr) {
+			t.Errorf("parseChannelsString(%q) error = %v, want a ChannelSpecError", test.str, err)
+			continue
+		}
+		if specErr.Pos != test.pos {
+			t.Errorf("parseChannelsString(%q) error at %d, want %d: %v", test.str, specErr.Pos, test.pos, err)
+		}
+	}
+
+	if _, _, err := parseChannelsString("1-4,!1-4", 8, true); err == nil {
+		t.Error("parseChannelsString without channels left succeeded")
+	}
+}
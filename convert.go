@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"github.com/calebmcelroy/wav-extract/wav"
+	"math"
+	"strings"
+)
+
+const (
+	ditherNone   = "none"
+	ditherTPDF   = "tpdf"   // triangular dither of ±1 LSB
+	ditherShaped = "shaped" // triangular dither with first-order noise shaping
+
+	// frames between restarts of the noise shaping error, the files of a track
+	// converted in parallel pick it up from the frames since the last restart
+	ditherBlockFrames = 4096
+)
+
+// parseBitDepth parses a --bit-depth value: 16, 24, 32 or 32f for float.
+func parseBitDepth(str string) (format, bitsPerSample int, err error) {
+	switch strings.ToLower(strings.TrimSpace(str)) {
+	case "16":
+		return wav.FormatPCM, 16, nil
+	case "24":
+		return wav.FormatPCM, 24, nil
+	case "32":
+		return wav.FormatPCM, 32, nil
+	case "32f":
+		return wav.FormatIEEEFloat, 32, nil
+	}
+	return 0, 0, fmt.Errorf("invalid bit depth %s, expected 16, 24, 32 or 32f", str)
+}
+
+// parseDither checks a --dither value.
+func parseDither(str string) (string, error) {
+	switch dither := strings.ToLower(strings.TrimSpace(str)); dither {
+	case ditherNone, ditherTPDF, ditherShaped:
+		return dither, nil
+	}
+	return "", fmt.Errorf("invalid dither %s, expected none, tpdf or shaped", str)
+}
+
+// converter converts samples from one format to another. Dither is added
+// when the word length is reduced, except to digital silence.
+type converter struct {
+	decode wav.DecodeFunc
+	encode wav.EncodeFunc
+
+	lsb    float64 // output step to dither by, 0 without dither
+	shaped bool
+	errors []float64 // last quantization error of each channel
+	stream uint64    // dither noise of the track, see seed
+	frame  int64     // frame of the next sample
+}
+
+func newConverter(inFormat, inBits, outFormat, outBits int, dither string, numChans int) (*converter, error) {
+	decode, err := wav.Decoder(inFormat, inBits)
+	if err != nil {
+		return nil, err
+	}
+
+	encode, err := wav.Encoder(outFormat, outBits)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &converter{
+		decode: decode,
+		encode: encode,
+		errors: make([]float64, numChans),
+	}
+
+	// 32-bit integers are already finer than any source
+	reduced := outFormat == wav.FormatPCM && outBits < 32 && (inFormat == wav.FormatIEEEFloat || inBits > outBits)
+	if reduced && dither != ditherNone {
+		c.lsb = 1 / float64(int64(1)<<(outBits-1))
+		c.shaped = dither == ditherShaped
+	}
+
+	return c, nil
+}
+
+// seed sets the dither noise stream, e.g. of a track, and the frame of the
+// next sample.
+func (c *converter) seed(stream int, frame int64) {
+	c.stream, c.frame = uint64(stream), frame
+}
+
+// warmUp runs the frames just before the converted ones, one slice per
+// channel, through the noise shaping without writing them.
+func (c *converter) warmUp(frames [][]float64) {
+	if !c.shaped {
+		return
+	}
+
+	// from the last restart of the error
+	n := min(int(c.frame%ditherBlockFrames), len(frames[0]))
+	c.frame -= int64(n)
+
+	buf := make([]byte, 8)
+	for i := len(frames[0]) - n; i < len(frames[0]); i++ {
+		for ch := range frames {
+			c.encodeSample(buf, frames[ch][i], ch)
+		}
+	}
+}
+
+// convert converts the sample src of channel ch into dst.
+func (c *converter) convert(dst, src []byte, ch int) {
+	c.encodeSample(dst, c.decode(src), ch)
+}
+
+// encodeSample encodes v, a sample of channel ch, into dst.
+func (c *converter) encodeSample(dst []byte, v float64, ch int) {
+	if c.frame%ditherBlockFrames == 0 {
+		c.errors[ch] = 0
+	}
+
+	// digital silence stays silent
+	if c.lsb > 0 && v == 0 {
+		c.errors[ch] = 0
+	} else if c.lsb > 0 {
+		// feed back the last error so the noise moves to high frequencies
+		if c.shaped {
+			v -= c.errors[ch]
+		}
+
+		tpdf := (c.uniform(ch, 0) - c.uniform(ch, 1)) * c.lsb
+		q := math.Round((v+tpdf)/c.lsb) * c.lsb
+		q = max(min(q, 1-c.lsb), -1)
+
+		c.errors[ch] = q - v
+		v = q
+	}
+
+	c.encode(dst, v)
+	if ch == len(c.errors)-1 {
+		c.frame++
+	}
+}
+
+// uniform returns the i-th uniform random number in [0, 1) of sample ch of
+// the current frame, hashed from the stream and the frame.
+func (c *converter) uniform(ch, i int) float64 {
+	x := mix64(mix64(c.stream) ^ uint64(c.frame))
+	x = mix64(x ^ uint64(2*ch+i+1)*0x9e3779b97f4a7c15)
+	return float64(x>>11) / (1 << 53)
+}
+
+// mix64 is the finalizer of splitmix64, it scrambles the bits of x.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"github.com/calebmcelroy/wav-extract/wav"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var bitDepths = []string{"16", "24", "32", "32f"}
+
+// step returns the smallest step of a sample format.
+func step(format, bits int) float64 {
+	if format == wav.FormatIEEEFloat {
+		return 1.0 / (1 << 24)
+	}
+	return 1 / float64(int64(1)<<(bits-1))
+}
+
+func TestConverterPairs(t *testing.T) {
+	values := []float64{0, 0.5, -0.5, 0.123456789, -0.987654321, -1, 0.99}
+
+	for _, from := range bitDepths {
+		for _, to := range bitDepths {
+			for _, dither := range []string{ditherNone, ditherTPDF, ditherShaped} {
+				t.Run(fmt.Sprintf("%s-%s-%s", from, to, dither), func(t *testing.T) {
+					inFormat, inBits, _ := parseBitDepth(from)
+					outFormat, outBits, _ := parseBitDepth(to)
+
+					c, err := newConverter(inFormat, inBits, outFormat, outBits, dither, 1)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					encode, _ := wav.Encoder(inFormat, inBits)
+					decodeIn, _ := wav.Decoder(inFormat, inBits)
+					decodeOut, _ := wav.Decoder(outFormat, outBits)
+
+					// rounding to the coarser format, dither adds up to 1 LSB and
+					// noise shaping up to 1.5 LSB more
+					tolerance := max(step(inFormat, inBits), step(outFormat, outBits))
+					if c.lsb > 0 {
+						tolerance = 3 * c.lsb
+					}
+
+					in := make([]byte, inBits/8)
+					out := make([]byte, outBits/8)
+					for _, v := range values {
+						encode(in, v)
+						want := decodeIn(in)
+
+						c.convert(out, in, 0)
+						if got := decodeOut(out); math.Abs(got-want) > tolerance {
+							t.Errorf("convert(%v) = %v, want %v ± %v", want, got, want, tolerance)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestConverterLosslessPairs(t *testing.T) {
+	// every sample of the input format can be represented in the output format
+	pairs := [][2]string{{"16", "24"}, {"16", "32"}, {"24", "32"}, {"16", "32f"}, {"24", "32f"}, {"32f", "32f"}}
+	for _, pair := range pairs {
+		inFormat, inBits, _ := parseBitDepth(pair[0])
+		outFormat, outBits, _ := parseBitDepth(pair[1])
+
+		c, err := newConverter(inFormat, inBits, outFormat, outBits, ditherTPDF, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.lsb != 0 {
+			t.Errorf("%s to %s: dither added without reducing the word length", pair[0], pair[1])
+		}
+
+		encode, _ := wav.Encoder(inFormat, inBits)
+		decodeIn, _ := wav.Decoder(inFormat, inBits)
+		decodeOut, _ := wav.Decoder(outFormat, outBits)
+
+		in := make([]byte, inBits/8)
+		out := make([]byte, outBits/8)
+		for _, v := range []float64{0, 0.25, -0.3333, 0.7071, -1} {
+			encode(in, v)
+			c.convert(out, in, 0)
+			if got, want := decodeOut(out), decodeIn(in); got != want {
+				t.Errorf("%s to %s: convert(%v) = %v", pair[0], pair[1], want, got)
+			}
+		}
+	}
+}
+
+func TestConverterDither(t *testing.T) {
+	for _, dither := range []string{ditherTPDF, ditherShaped} {
+		c, err := newConverter(wav.FormatPCM, 24, wav.FormatPCM, 16, dither, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a level between two 16-bit steps is kept on average
+		lsb := 1.0 / (1 << 15)
+		want := 100.25 * lsb
+
+		encode, _ := wav.Encoder(wav.FormatPCM, 24)
+		decode, _ := wav.Decoder(wav.FormatPCM, 16)
+		in := make([]byte, 3)
+		out := make([]byte, 2)
+		encode(in, want)
+
+		sum := 0.0
+		levels := make(map[float64]bool)
+		const n = 100000
+		for range n {
+			c.convert(out, in, 0)
+			v := decode(out)
+			sum += v
+			levels[v] = true
+		}
+
+		if mean := sum / n; math.Abs(mean-want) > 0.01*lsb {
+			t.Errorf("%s: mean = %v LSB, want %v LSB", dither, mean/lsb, want/lsb)
+		}
+		if len(levels) < 2 {
+			t.Errorf("%s: output is not dithered", dither)
+		}
+	}
+
+	// without dither the level is rounded
+	c, _ := newConverter(wav.FormatPCM, 24, wav.FormatPCM, 16, ditherNone, 1)
+	in := []byte{0x40, 0x64, 0x00} // 100.25 16-bit steps
+	out := make([]byte, 2)
+	c.convert(out, in, 0)
+	if out[0] != 100 || out[1] != 0 {
+		t.Errorf("convert without dither = %v, want [100 0]", out)
+	}
+}
+
+func TestTrackDither(t *testing.T) {
+	opts := testTrackOptions(t.TempDir(), 0)
+	opts.SourceBitsPerSample = 24
+	tracks, err := initTracks("", "1,2", nil, 2, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// dither of a level between two 16-bit steps
+	lsb := 1.0 / (1 << 15)
+	level := 100.25 * lsb
+	dither := func(c *converter) []float64 {
+		decode, _ := wav.Decoder(wav.FormatPCM, 16)
+		out := make([]byte, 2)
+		noise := make([]float64, 20000)
+		for i := range noise {
+			c.encodeSample(out, level, 0)
+			noise[i] = decode(out) - level
+		}
+		return noise
+	}
+
+	correlation := func(a, b []float64) float64 {
+		var sumA, sumB, sumAB, sumA2, sumB2 float64
+		for i := range a {
+			sumA += a[i]
+			sumB += b[i]
+			sumAB += a[i] * b[i]
+			sumA2 += a[i] * a[i]
+			sumB2 += b[i] * b[i]
+		}
+		n := float64(len(a))
+		return (sumAB/n - sumA/n*sumB/n) / math.Sqrt((sumA2/n-sumA/n*sumA/n)*(sumB2/n-sumB/n*sumB/n))
+	}
+
+	// tracks, and the frames of a track, get their own noise
+	first := dither(tracks[0].newConverter(0))
+	if r := correlation(first, dither(tracks[1].newConverter(0))); math.Abs(r) > 0.05 {
+		t.Errorf("dither of two tracks is correlated (%.3f)", r)
+	}
+	if r := correlation(first, dither(tracks[0].newConverter(24000))); math.Abs(r) > 0.05 {
+		t.Errorf("dither of other frames of a track is correlated (%.3f)", r)
+	}
+	if r := correlation(first, dither(tracks[0].newConverter(0))); r < 0.999 {
+		t.Errorf("dither of the same frames differs (%.3f)", r)
+	}
+}
+
+// The dither noise and the noise shaping carry on across the seams of the
+// input files, so a recording split into files converts the same as one file.
+func TestDitherAcrossFiles(t *testing.T) {
+	const sampleRate = 48000
+
+	frames := make([][]float64, 2)
+	for ch := range frames {
+		frames[ch] = make([]float64, 20000)
+		for i := range frames[ch] {
+			frames[ch][i] = 0.3 * math.Sin(2*math.Pi*440*float64(i+10*ch)/sampleRate)
+		}
+	}
+
+	for _, dither := range []string{ditherTPDF, ditherShaped} {
+		for _, outputRate := range []int{48000, 44100} {
+			convert := func(lengths ...int) [][]float64 {
+				opts := testFloatTrackOptions(t.TempDir(), int64(len(frames[0])))
+				opts.AudioFormat, opts.BitsPerSample, opts.Dither = wav.FormatPCM, 16, dither
+				opts.SampleRate = outputRate
+
+				tracks, err := initTracks("1/2", "", nil, 2, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				extractTestTracks(t, openTestWavs(t, sampleRate, frames, lengths...), tracks)
+
+				converted, _ := readTestWav(t, filepath.Join(opts.OutputDir, tracks[0].Name+".wav"))
+				return converted
+			}
+
+			if whole, files := convert(20000), convert(5000, 9000, 6000); !reflect.DeepEqual(whole, files) {
+				t.Errorf("%s, %d Hz: samples of one file and of 3 files differ", dither, outputRate)
+			}
+		}
+	}
+}
+
+func TestParseBitDepth(t *testing.T) {
+	tests := []struct {
+		str    string
+		format int
+		bits   int
+	}{
+		{"16", wav.FormatPCM, 16},
+		{"24", wav.FormatPCM, 24},
+		{"32", wav.FormatPCM, 32},
+		{"32f", wav.FormatIEEEFloat, 32},
+		{"32F", wav.FormatIEEEFloat, 32},
+	}
+	for _, test := range tests {
+		format, bits, err := parseBitDepth(test.str)
+		if err != nil || format != test.format || bits != test.bits {
+			t.Errorf("parseBitDepth(%q) = %d, %d, %v", test.str, format, bits, err)
+		}
+	}
+
+	for _, str := range []string{"", "8", "64f", "float"} {
+		if _, _, err := parseBitDepth(str); err == nil {
+			t.Errorf("parseBitDepth(%q) succeeded", str)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// windows spread over the recording that are correlated by --auto-align
+	alignWindows      = 6
+	alignWindowFrames = 1 << 16
+
+	// largest offset searched for by default
+	alignDefaultMaxLag = "100ms"
+
+	// tracks less correlated with the reference are left as they are
+	alignMinCorrelation = 0.2
+)
+
+// parseDelays parses --delay offsets, e.g. 12=+3.2ms,13=-48smp, into output
+// frames by zero-based channel.
+func parseDelays(str string, numChans, inRate, outRate int) (map[int]int64, error) {
+	delays := make(map[int]int64)
+	for _, item := range strings.Split(str, ",") {
+		channelStr, delayStr, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid delay %s, expected channel=offset (e.g. 12=+3.2ms)", item)
+		}
+
+		ch, err := strconv.Atoi(strings.TrimSpace(channelStr))
+		if err != nil || ch < 1 || ch > numChans {
+			return nil, fmt.Errorf("invalid channel %s, channels must be between 1 and %d", channelStr, numChans)
+		}
+		if _, ok := delays[ch-1]; ok {
+			return nil, fmt.Errorf("duplicate delay for channel %d", ch)
+		}
+
+		delay, err := parseDelay(delayStr, inRate, outRate)
+		if err != nil {
+			return nil, err
+		}
+		delays[ch-1] = delay
+	}
+
+	return delays, nil
+}
+
+// parseDelay parses a signed offset, positive offsets delay the audio.
+func parseDelay(str string, inRate, outRate int) (int64, error) {
+	str = strings.TrimSpace(str)
+	sign := int64(1)
+	if rest, ok := strings.CutPrefix(str, "-"); ok {
+		sign, str = -1, rest
+	} else {
+		str = strings.TrimPrefix(str, "+")
+	}
+
+	if strings.HasSuffix(str, "smp") {
+		frames, err := parsePosition(str, inRate)
+		if err != nil {
+			return 0, err
+		}
+		return sign * convertFrames(frames, inRate, outRate), nil
+	}
+
+	frames, err := parsePosition(str, outRate)
+	if err != nil {
+		return 0, err
+	}
+	return sign * frames, nil
+}
+
+// parseAutoAlign parses --auto-align options into the zero-based reference
+// channel and the largest offset in frames.
+func parseAutoAlign(str string, numChans, sampleRate int) (ref int, maxLag int64, err error) {
+	ref = -1
+	maxLag, _ = parsePosition(alignDefaultMaxLag, sampleRate)
+
+	for _, option := range strings.Split(str, ",") {
+		key, value, _ := strings.Cut(option, "=")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "ref":
+			ch, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || ch < 1 || ch > numChans {
+				return 0, 0, fmt.Errorf("invalid reference channel %s, channels must be between 1 and %d", value, numChans)
+			}
+			ref = ch - 1
+		case "max":
+			maxLag, err = parsePosition(value, sampleRate)
+			if err != nil || maxLag == 0 || maxLag >= alignWindowFrames/2 {
+				return 0, 0, fmt.Errorf("invalid max offset %s, must be greater than 0 and below %s", value, formatPosition(alignWindowFrames/2, sampleRate))
+			}
+		default:
+			return 0, 0, fmt.Errorf("invalid option %s, expected ref=<channel> and optionally max=<duration>", option)
+		}
+	}
+
+	if ref < 0 {
+		return 0, 0, fmt.Errorf("missing reference channel, e.g. ref=1")
+	}
+
+	return ref, maxLag, nil
+}
+
+// Alignment is the offset of a track found by --auto-align.
+type Alignment struct {
+	Track       *Track
+	Offset      int64   // input frames the track lags the reference by
+	Correlation float64 // normalized, negative for inverted polarity
+	Aligned     bool
+}
+
+func (a Alignment) String(sampleRate int) string {
+	if !a.Aligned {
+		return fmt.Sprintf("%-20s correlation %5.2f  not aligned", a.Track.Name, a.Correlation)
+	}
+	ms := float64(a.Offset) * 1000 / float64(sampleRate)
+	return fmt.Sprintf("%-20s correlation %5.2f  lags by %+8.2f ms (%+d smp)", a.Track.Name, a.Correlation, ms, a.Offset)
+}
+
+// estimateAlignments returns the lag of each track to the reference channel,
+// found by cross-correlating windows of the timeline range [start, end).
+func estimateAlignments(wavFiles []*WavFile, tracks []*Track, ref int, maxLag, start, end int64) ([]Alignment, error) {
+	numChans := wavFiles[0].NumChans
+	windowFrames := min(int64(alignWindowFrames), end-start)
+	windows := int64(alignWindows)
+	if (end-start)/windowFrames < windows {
+		windows = max((end-start)/windowFrames, 1)
+	}
+
+	// zero padded so the correlation doesn't wrap around
+	size := 1
+	for size < int(2*windowFrames) {
+		size *= 2
+	}
+
+	var alignments []Alignment
+	for _, track := range tracks {
+		if !slices.Contains(track.Channels, ref) {
+			alignments = append(alignments, Alignment{Track: track})
+		}
+	}
+
+	spectra := make([][]complex128, len(alignments))
+	energies := make([]float64, len(alignments))
+	for i := range spectra {
+		spectra[i] = make([]complex128, size)
+	}
+	refEnergy := 0.0
+
+	frames := make([][]float64, numChans)
+	for ch := range frames {
+		frames[ch] = make([]float64, windowFrames)
+	}
+
+	for w := int64(0); w < windows; w++ {
+		pos := start + (end-start-windowFrames)*w/max(windows-1, 1)
+		n, err := readTimelineFrames(wavFiles, pos, frames)
+		if err != nil {
+			return nil, err
+		}
+
+		refSpectrum := make([]complex128, size)
+		for i, v := range frames[ref][:n] {
+			refSpectrum[i] = complex(v, 0)
+			refEnergy += v * v
+		}
+		fft(refSpectrum, false)
+
+		for i, alignment := range alignments {
+			spectrum := make([]complex128, size)
+			mixed := alignment.Track.mix(channelFrames(frames, alignment.Track.Channels))
+			for _, channel := range mixed {
+				for j, v := range channel[:n] {
+					spectrum[j] += complex(v, 0)
+				}
+			}
+			for _, v := range spectrum {
+				energies[i] += real(v) * real(v)
+			}
+			fft(spectrum, false)
+
+			for j := range spectrum {
+				spectra[i][j] += spectrum[j] * cmplx.Conj(refSpectrum[j])
+			}
+		}
+	}
+
+	for i := range alignments {
+		if refEnergy == 0 || energies[i] == 0 {
+			continue
+		}
+
+		correlation := spectra[i]
+		fft(correlation, true)
+
+		// lag k is at index k, negative lags wrap to the end
+		best, bestLag := 0.0, int64(0)
+		for lag := -maxLag; lag <= maxLag; lag++ {
+			index := (lag + int64(size)) % int64(size)
+			if v := real(correlation[index]); math.Abs(v) > math.Abs(best) {
+				best, bestLag = v, lag
+			}
+		}
+
+		alignments[i].Correlation = best / math.Sqrt(refEnergy*energies[i])
+		alignments[i].Offset = bestLag
+		alignments[i].Aligned = math.Abs(alignments[i].Correlation) >= alignMinCorrelation
+	}
+
+	return alignments, nil
+}
+
+// applyAlignments delays the aligned tracks to remove their lag, except those
+// with channels given to --delay.
+func applyAlignments(alignments []Alignment, delays map[int]int64, inRate, outRate int) {
+	for _, alignment := range alignments {
+		if !alignment.Aligned || slices.ContainsFunc(alignment.Track.Channels, func(ch int) bool {
+			_, ok := delays[ch]
+			return ok
+		}) {
+			continue
+		}
+
+		delay := convertFrames(max(alignment.Offset, -alignment.Offset), inRate, outRate)
+		if alignment.Offset > 0 {
+			delay = -delay
+		}
+		alignment.Track.Delay = delay
+	}
+}
+
+// fft transforms x in place, its length must be a power of two. The inverse
+// transform is scaled by 1/len(x).
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+
+	// bit reversed order
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := range size / 2 {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+
+	if inverse {
+		for i := range x {
+			x[i] /= complex(float64(n), 0)
+		}
+	}
+}
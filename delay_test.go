@@ -0,0 +1,201 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDelays(t *testing.T) {
+	tests := []struct {
+		str             string
+		inRate, outRate int
+		delays          map[int]int64
+	}{
+		{"1=120smp", 48000, 48000, map[int]int64{0: 120}},
+		{"12=+2.5ms, 13=-48smp", 48000, 48000, map[int]int64{11: 120, 12: -48}},
+		{" 2 = -1.5s ,3=0", 48000, 48000, map[int]int64{1: -72000, 2: 0}},
+		// samples are input samples, durations are at the output rate
+		{"1=-48smp,2=+2.5ms,3=480smp", 48000, 44100, map[int]int64{0: -44, 1: 110, 2: 441}},
+		{"1=441smp", 44100, 96000, map[int]int64{0: 960}},
+	}
+
+	for _, test := range tests {
+		delays, err := parseDelays(test.str, 16, test.inRate, test.outRate)
+		if err != nil {
+			t.Errorf("parseDelays(%q): %v", test.str, err)
+			continue
+		}
+		if !reflect.DeepEqual(delays, test.delays) {
+			t.Errorf("parseDelays(%q, %d to %d Hz) = %v, want %v", test.str, test.inRate, test.outRate, delays, test.delays)
+		}
+	}
+
+	for _, str := range []string{
+		"",
+		"1",
+		"1:3ms",
+		"0=3ms",
+		"17=3ms",
+		"x=3ms",
+		"1=3ms,1=-3ms",
+		"1=",
+		"1=3xs",
+		"1=--3ms",
+		"1=+-3ms",
+		"1=-3.5smp",
+	} {
+		if delays, err := parseDelays(str, 16, 48000, 48000); err == nil {
+			t.Errorf("parseDelays(%q) = %v, want an error", str, delays)
+		}
+	}
+}
+
+func TestParseAutoAlign(t *testing.T) {
+	tests := []struct {
+		str    string
+		ref    int
+		maxLag int64
+	}{
+		{"ref=1", 0, 4800},
+		{" REF = 16 ", 15, 4800},
+		{"max=10ms,ref=3", 2, 480},
+		{"ref=2,max=1000smp", 1, 1000},
+	}
+
+	for _, test := range tests {
+		ref, maxLag, err := parseAutoAlign(test.str, 16, 48000)
+		if err != nil {
+			t.Errorf("parseAutoAlign(%q): %v", test.str, err)
+			continue
+		}
+		if ref != test.ref || maxLag != test.maxLag {
+			t.Errorf("parseAutoAlign(%q) = %d, %d, want %d, %d", test.str, ref, maxLag, test.ref, test.maxLag)
+		}
+	}
+
+	for _, str := range []string{
+		"",
+		"max=10ms",
+		"ref=0",
+		"ref=17",
+		"ref=1,max=0ms",
+		"ref=1,max=1s",
+		"ref=1,max=-5ms",
+		"ref=1,lag=5ms",
+	} {
+		if _, _, err := parseAutoAlign(str, 16, 48000); err == nil {
+			t.Errorf("parseAutoAlign(%q) succeeded, want an error", str)
+		}
+	}
+}
+
+// The channels of a track are delayed together, including the inputs of a
+// bus.
+func TestTrackDelays(t *testing.T) {
+	opts := testTrackOptions(t.TempDir(), 48000)
+	opts.Delays = map[int]int64{0: 100, 1: 100, 2: -20}
+
+	tracks, err := initTracks("1/2", "", nil, 4, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int64{100, -20, 0} {
+		if tracks[i].Delay != want {
+			t.Errorf("%s delayed by %d, want %d", tracks[i].Name, tracks[i].Delay, want)
+		}
+	}
+
+	bus, _ := parseBus("Mix=1/2,4", 4)
+	for _, test := range []struct {
+		stereo string
+		buses  []Bus
+	}{
+		{"2/3", nil},
+		{"3/4", nil},
+		{"", []Bus{bus}},
+	} {
+		_, err := initTracks(test.stereo, "", test.buses, 4, opts)
+		if err == nil || !strings.Contains(err.Error(), "need the same --delay offset") {
+			t.Errorf("stereo %q, buses %v: error %v, want different delays", test.stereo, test.buses, err)
+		}
+	}
+}
+
+// Copies of a noise channel that are delayed, moved earlier and inverted are
+// found at their offset, an unrelated channel is left as it is.
+func TestEstimateAlignments(t *testing.T) {
+	const sampleRate = 48000
+	const lag, lead = 37, 12
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	noise := make([]float64, 2*sampleRate+lag+lead)
+	for i := range noise {
+		noise[i] = 0.5 * rng.NormFloat64()
+	}
+
+	frames := make([][]float64, 4)
+	for ch := range frames {
+		frames[ch] = make([]float64, 2*sampleRate)
+	}
+	for i := range frames[0] {
+		frames[0][i] = noise[lag+i]
+		frames[1][i] = noise[i]
+		frames[2][i] = -noise[lag+lead+i]
+		frames[3][i] = 0.5 * rng.NormFloat64()
+	}
+
+	opts := testFloatTrackOptions(t.TempDir(), 2*sampleRate)
+	opts.SampleRate = 44100
+	tracks, err := initTracks("", "", nil, 4, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wavFiles := openTestWavs(t, sampleRate, frames, sampleRate, sampleRate)
+
+	maxLag, _ := parsePosition(alignDefaultMaxLag, sampleRate)
+	alignments, err := estimateAlignments(wavFiles, tracks, 0, maxLag, 0, 2*sampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alignments) != 3 {
+		t.Fatalf("%d alignments, want 3 without the reference", len(alignments))
+	}
+
+	want := []struct {
+		offset  int64
+		aligned bool
+		sign    float64
+	}{
+		{lag, true, 1},
+		{-lead, true, -1},
+		{0, false, 0},
+	}
+	for i, alignment := range alignments {
+		if alignment.Track != tracks[i+1] {
+			t.Fatalf("alignment %d is of %s, want %s", i, alignment.Track.Name, tracks[i+1].Name)
+		}
+		if alignment.Aligned != want[i].aligned {
+			t.Errorf("%s aligned = %v with correlation %.2f", alignment.Track.Name, alignment.Aligned, alignment.Correlation)
+		}
+		if !want[i].aligned {
+			continue
+		}
+		if alignment.Offset != want[i].offset || alignment.Correlation*want[i].sign < 0.99 {
+			t.Errorf("%s offset %d with correlation %.2f, want %d with %+g", alignment.Track.Name, alignment.Offset, alignment.Correlation, want[i].offset, want[i].sign)
+		}
+	}
+
+	// the lag is removed at the output rate, --delay takes precedence
+	applyAlignments(alignments, map[int]int64{2: 5}, sampleRate, opts.SampleRate)
+	for i, want := range []int64{0, -34, 0, 0} {
+		if tracks[i].Delay != want {
+			t.Errorf("%s delayed by %d, want %d", tracks[i].Name, tracks[i].Delay, want)
+		}
+	}
+	if math.Abs(alignments[2].Correlation) >= alignMinCorrelation {
+		t.Errorf("correlation of unrelated noise = %.2f", alignments[2].Correlation)
+	}
+}
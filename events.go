@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+const (
+	eventClip    = "clip"
+	eventDropout = "dropout"
+
+	// shortest runs of full scale samples and of digital silence reported
+	clipMinSamples    = 3
+	dropoutMinSeconds = 0.002
+)
+
+// Event is a run of clipped samples, or of digital silence in the middle of
+// a signal (a dropout), on one channel.
+type Event struct {
+	Kind    string
+	Track   string
+	Channel int // zero-based input channel, -1 if mixed from several channels
+
+	// frames [Start, End) of the track
+	Start int64
+	End   int64
+
+	// where the event starts on the timeline and in the input files, the
+	// timeline position is the position of the file plus FileFrame
+	Position  int64
+	File      string
+	FileFrame int64
+}
+
+func (e Event) Label() string {
+	what := "Clipping"
+	if e.Kind == eventDropout {
+		what = "Dropout"
+	}
+	if e.Channel < 0 {
+		return fmt.Sprintf("%s (%s)", what, e.Track)
+	}
+	return fmt.Sprintf("%s ch %d (%s)", what, e.Channel+1, e.Track)
+}
+
+// channelName returns the one-based input channel, or "mix".
+func (e Event) channelName() string {
+	if e.Channel < 0 {
+		return "mix"
+	}
+	return strconv.Itoa(e.Channel + 1)
+}
+
+// eventRun is a run of samples of the same kind on a channel.
+type eventRun struct {
+	kind  string // "" for normal samples
+	start int64
+}
+
+// minEventFrames returns the length of the shortest event of a kind.
+func minEventFrames(kind string, sampleRate int) int64 {
+	if kind == eventDropout {
+		return max(int64(dropoutMinSeconds*float64(sampleRate)), 1)
+	}
+	return clipMinSamples
+}
+
+// addRun adds the next sample to the run of its channel.
+func (a *analyzer) addRun(v float64) {
+	kind := ""
+	if v == 0 {
+		kind = eventDropout
+	} else if math.Abs(v) >= a.clipLevel {
+		kind = eventClip
+	}
+
+	if kind != a.runs[a.channel].kind {
+		a.endRun(a.channel, a.frame, false)
+		a.runs[a.channel] = eventRun{kind, a.frame}
+	}
+}
+
+// endRun ends the current run of the channel at frame end. Short runs are
+// only kept at the edges of the analyzer, where they may continue in the
+// frames of another file.
+func (a *analyzer) endRun(ch int, end int64, atEdge bool) {
+	run := a.runs[ch]
+	a.runs[ch] = eventRun{}
+	if run.kind == "" {
+		return
+	}
+
+	if !atEdge && run.start != a.start && end-run.start < minEventFrames(run.kind, a.sampleRate) {
+		return
+	}
+
+	// the input files may have another sample rate than the track
+	fileFrame := a.fileFrame + convertFrames(run.start-a.start, a.sampleRate, a.sourceRate)
+	a.events = append(a.events, Event{
+		Kind:      run.kind,
+		Channel:   ch,
+		Start:     run.start,
+		End:       end,
+		Position:  a.filePos + fileFrame,
+		File:      a.file,
+		FileFrame: fileFrame,
+	})
+}
+
+// flush ends the runs still open at the end of the analyzed frames.
+func (a *analyzer) flush() {
+	for ch := range a.runs {
+		a.endRun(ch, a.frame, true)
+	}
+}
+
+// Events returns the clipping and dropouts found while extracting the track,
+// ordered by position.
+func (t *Track) Events() []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	frames := t.frames()
+	runs := append([]Event(nil), t.events...)
+	sort.Slice(runs, func(i, j int) bool {
+		if runs[i].Channel != runs[j].Channel {
+			return runs[i].Channel < runs[j].Channel
+		}
+		return runs[i].Start < runs[j].Start
+	})
+
+	// join runs split between files
+	var joined []Event
+	for _, run := range runs {
+		if last := len(joined) - 1; last >= 0 && joined[last].Channel == run.Channel && joined[last].Kind == run.Kind && joined[last].End == run.Start {
+			joined[last].End = run.End
+			continue
+		}
+		joined = append(joined, run)
+	}
+
+	var events []Event
+	for _, event := range joined {
+		if event.End-event.Start < minEventFrames(event.Kind, t.opts.SampleRate) {
+			continue
+		}
+
+		// silence at the start or end of the track is not a dropout
+		if event.Kind == eventDropout && (event.Start == 0 || event.End == frames) {
+			continue
+		}
+
+		// delayed tracks are shifted and cut to their length
+		event.Start = max(event.Start+t.Delay, 0)
+		event.End = min(event.End+t.Delay, frames)
+		if event.Start >= event.End {
+			continue
+		}
+
+		event.Track = t.Name
+		event.Channel = t.inputChannel(event.Channel)
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start < events[j].Start
+	})
+
+	return events
+}
+
+// AddMarkers adds markers, in frames of the track, to the cue points of the
+// track. Added markers that don't fit in a segment limited by size are left
+// out, see segmentCuePoints.
+func (t *Track) AddMarkers(markers []Marker) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.added = append(t.added, markers...)
+	sort.SliceStable(t.added, func(i, j int) bool {
+		return t.added[i].Position < t.added[j].Position
+	})
+}
+
+func printEvents(events []Event, sampleRate int) {
+	if len(events) == 0 {
+		fmt.Println("No clipping or dropouts found.")
+		return
+	}
+
+	fmt.Printf("Found %d clipping & dropout events:\n", len(events))
+	for _, e := range events {
+		fmt.Printf("  %s  %-8s ch %-3s %-20s %6d samples  (%s at %s)\n", formatPosition(e.Position, sampleRate), e.Kind, e.channelName(), e.Track, e.End-e.Start, e.File, formatPosition(e.FileFrame, sampleRate))
+	}
+}
+
+// writeEvents saves the events as a CSV file, its position and label columns
+// can be read back with --markers.
+func writeEvents(path string, events []Event, sampleRate int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"position", "label", "type", "track", "channel", "samples", "position_frame", "file", "file_position"})
+	for _, e := range events {
+		w.Write([]string{
+			formatPosition(e.Position, sampleRate),
+			e.Label(),
+			e.Kind,
+			e.Track,
+			e.channelName(),
+			strconv.FormatInt(e.End-e.Start, 10),
+			strconv.FormatInt(e.Position, 10),
+			e.File,
+			formatPosition(e.FileFrame, sampleRate),
+		})
+	}
+	w.Flush()
+
+	return w.Error()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	const sampleRate = 48000
+
+	frames := make([][]float64, 2)
+	for ch := range frames {
+		frames[ch] = make([]float64, sampleRate)
+		for i := range frames[ch] {
+			frames[ch][i] = 0.5 * math.Sin(2*math.Pi*1001*float64(i)/sampleRate+1)
+		}
+	}
+	fill := func(ch, from, to int, v float64) {
+		for i := from; i < to; i++ {
+			frames[ch][i] = v
+		}
+	}
+
+	fill(0, 0, 100, 0)        // silence at the start
+	fill(0, 1000, 1005, 1)    // clipping
+	fill(0, 2000, 2002, 1)    // too short
+	fill(0, 10000, 10200, 0)  // dropout
+	fill(0, 12000, 12050, 0)  // too short
+	fill(0, 23950, 24050, 0)  // dropout across the files
+	fill(0, 47900, 48000, 0)  // silence at the end
+	fill(1, 30000, 30003, -1) // clipping in the second file
+
+	opts := testFloatTrackOptions(t.TempDir(), sampleRate)
+	opts.DetectEvents = true
+	opts.Delays = map[int]int64{1: 100}
+	tracks, err := initTracks("", "1,2", nil, 2, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wavFiles := openTestWavs(t, sampleRate, frames, 24000, 24000)
+	extract(context.Background(), wavFiles, tracks, 0, sampleRate, time.Millisecond, func(Progress) {})
+
+	want := [][]Event{
+		{
+			{eventClip, "track_1", 0, 1000, 1005, 1000, "00000001.WAV", 1000},
+			{eventDropout, "track_1", 0, 10000, 10200, 10000, "00000001.WAV", 10000},
+			{eventDropout, "track_1", 0, 23950, 24050, 23950, "00000001.WAV", 23950},
+		},
+		// delayed tracks are shifted, the positions on the recording are not
+		{
+			{eventClip, "track_2", 1, 30100, 30103, 30000, "00000002.WAV", 6000},
+		},
+	}
+
+	var all []Event
+	for i, track := range tracks {
+		events := track.Events()
+		if !reflect.DeepEqual(events, want[i]) {
+			t.Errorf("%s events = %v, want %v", track.Name, events, want[i])
+		}
+		all = append(all, events...)
+
+		markers := make([]Marker, len(events))
+		for i, event := range events {
+			markers[i] = Marker{event.Start, event.Label()}
+		}
+		track.AddMarkers(markers)
+		if err := track.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// --event-markers
+	_, cues := readTestWav(t, filepath.Join(opts.OutputDir, "track_2.wav"))
+	if len(cues) != 1 || cues[0].Position != 30100 || cues[0].Label != "Clipping ch 2 (track_2)" {
+		t.Errorf("track_2 cue points = %v", cues)
+	}
+
+	// events.csv can be used with --markers
+	path := filepath.Join(t.TempDir(), "events.csv")
+	if err := writeEvents(path, all, sampleRate); err != nil {
+		t.Fatal(err)
+	}
+	markers, err := readMarkersCSV(path, sampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(markers) != len(all) {
+		t.Fatalf("read %d markers from events.csv, want %d", len(markers), len(all))
+	}
+	for i, marker := range markers {
+		// positions are saved in milliseconds
+		if marker.Label != all[i].Label() || marker.Position/48 != all[i].Position/48 {
+			t.Errorf("marker %v, want %s at %d", marker, all[i].Label(), all[i].Position)
+		}
+	}
+}
+
+func TestEventsJoinRuns(t *testing.T) {
+	track := &Track{opts: TrackOptions{SampleRate: 48000, SourceFrames: 10000}, Name: "track_1", Channels: []int{4}}
+
+	// runs split between three files, the middle file is all silence
+	track.events = []Event{
+		{Kind: eventDropout, Channel: 0, Start: 3000, End: 3500},
+		{Kind: eventDropout, Channel: 0, Start: 2950, End: 3000},
+		{Kind: eventDropout, Channel: 0, Start: 3500, End: 3510},
+		{Kind: eventClip, Channel: 0, Start: 4000, End: 4001},
+		{Kind: eventClip, Channel: 0, Start: 4001, End: 4002},
+		{Kind: eventClip, Channel: 0, Start: 5000, End: 5001},
+		{Kind: eventClip, Channel: 0, Start: 5002, End: 5003},
+		{Kind: eventDropout, Channel: 0, Start: 9990, End: 10000},
+	}
+
+	events := track.Events()
+	if len(events) != 1 || events[0].Start != 2950 || events[0].End != 3510 || events[0].Channel != 4 || events[0].Track != "track_1" {
+		t.Errorf("events = %v, want one dropout from 2950 to 3510 on channel 5", events)
+	}
+
+	// a third sample makes the clip long enough, runs with a gap stay apart
+	track.events = append(track.events, Event{Kind: eventClip, Channel: 0, Start: 4002, End: 4003})
+	if events := track.Events(); len(events) != 2 || events[1].Start != 4000 || events[1].End != 4003 {
+		t.Errorf("events = %v, want the dropout and a clip from 4000 to 4003", events)
+	}
+}
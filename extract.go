@@ -50,11 +50,23 @@ func initReaders(files []string) ([]*WavFile, error) {
 			return nil, fmt.Errorf("invalid WAV file (%s): %w", file, err)
 		}
 
+		// extensible files are accepted as long as they hold PCM or float samples
+		if format := wavFile.SampleFormat(); format != wav.FormatPCM && format != wav.FormatIEEEFloat {
+			return nil, fmt.Errorf("unsupported audio format 0x%04X (%s), only PCM and IEEE float are supported", wavFile.AudioFormat, file)
+		}
+
+		if wavFile.SampleFormat() == wav.FormatIEEEFloat && wavFile.BitsPerSample != 32 && wavFile.BitsPerSample != 64 {
+			return nil, fmt.Errorf("unsupported float bit depth %d (%s), only 32 and 64-bit are supported", wavFile.BitsPerSample, file)
+		}
+
 		wavFiles[i] = &wavFile
 	}
 
 	// make sure all channels, sample rates, & bit rates are the same
 	for i := 1; i < len(wavFiles); i++ {
+		if wavFiles[i].SampleFormat() != wavFiles[i-1].SampleFormat() {
+			return nil, fmt.Errorf("audio format mismatch: 0x%04X (%s) != 0x%04X (%s)", wavFiles[i].SampleFormat(), filepath.Base(files[i]), wavFiles[i-1].SampleFormat(), filepath.Base(files[i-1]))
+		}
 		if wavFiles[i].SampleRate != wavFiles[i-1].SampleRate {
 			return nil, fmt.Errorf("sample rate mismatch: %d (%s) != %d (%s)", wavFiles[i].SampleRate, filepath.Base(files[i]), wavFiles[i-1].SampleRate, filepath.Base(files[i-1]))
 		}
@@ -69,18 +81,15 @@ func initReaders(files []string) ([]*WavFile, error) {
 	return wavFiles, nil
 }
 
-func extract(ctx context.Context, wavFiles []*WavFile, tracks []*Track, progressInterval time.Duration, progressFunc func(p Progress)) {
+// extract writes frames [start, end) of the timeline formed by the wav files
+// one after another to the tracks.
+func extract(ctx context.Context, wavFiles []*WavFile, tracks []*Track, start, end int64, progressInterval time.Duration, progressFunc func(p Progress)) {
 	totalBytes := int64(0)
 	bytesProcessed := &atomic.Int64{}
-	wavFilePositions := make([]int64, len(wavFiles))
+	wavFilePositions := timelinePositions(wavFiles)
 	for i, wavFile := range wavFiles {
-		wavFilePositions[i] = totalBytes / int64(wavFile.NumChans)
-		err := wavFile.ReadHeader()
-		if err != nil {
-			fmt.Printf("Error reading PCM data length: %v\n", err)
-			os.Exit(1)
-		}
-		totalBytes += int64(wavFile.DataSize)
+		readStart, readEnd := fileRange(wavFile, wavFilePositions[i], start, end)
+		totalBytes += (readEnd - readStart) * int64(wavFile.BlockAlign)
 	}
 
 	done := false
@@ -112,7 +121,22 @@ func extract(ctx context.Context, wavFiles []*WavFile, tracks []*Track, progress
 	for i, wavFile := range wavFiles {
 		go func() {
 			defer wg.Done()
-			err := extractTracks(ctx, wavFile, tracks, intBufferPool, bytesProcessed, wavFilePositions[i])
+
+			// skip files outside of the range
+			readStart, readEnd := fileRange(wavFile, wavFilePositions[i], start, end)
+			if readStart >= readEnd {
+				return
+			}
+
+			tracksPos := wavFilePositions[i] + readStart - start
+			edges, err := resampleEdges(wavFiles, tracks, start, end, wavFilePositions[i]+readStart, wavFilePositions[i]+readEnd)
+			var lead *leadIn
+			if err == nil {
+				lead, err = readLeadIn(wavFiles, tracks, start, end, wavFilePositions[i]+readStart)
+			}
+			if err == nil {
+				err = extractTracks(ctx, wavFile, wavFilePositions[i], tracks, intBufferPool, bytesProcessed, tracksPos, readStart, readEnd, edges, lead)
+			}
 			if err != nil {
 				fmt.Println()
 				fmt.Printf("Error processing file %s: %v\n", wavFile.Name, err)
@@ -125,15 +149,98 @@ func extract(ctx context.Context, wavFiles []*WavFile, tracks []*Track, progress
 	done = true
 }
 
-func extractTracks(ctx context.Context, wavFile *WavFile, tracks []*Track, bufPool *sync.Pool, bytesProcessed *atomic.Int64, tracksPos int64) error {
-	trackPos := make([]int64, len(tracks))
-	for i, track := range tracks {
-		trackPos[i] = tracksPos * int64(len(track.Channels))
+// leadIn is the part of the timeline before a file that warms up the
+// analyzers and the noise shaping of its tracks.
+type leadIn struct {
+	frames [][]float64 // timeline frames [from-warm-taps, from+taps)
+	warm   int
+	taps   int // resampler context on both sides
+}
+
+// readLeadIn reads the lead-in of the frames starting at timeline frame
+// from, or returns nil if no track needs one or from is the start of the
+// extracted range.
+func readLeadIn(wavFiles []*WavFile, tracks []*Track, start, end, from int64) (*leadIn, error) {
+	warm := int64(0)
+	for _, track := range tracks {
+		if track.opts.Report {
+			warm = max(warm, int64(analysisWarmUpSeconds*float64(wavFiles[0].SampleRate)))
+		}
+		if track.opts.Dither == ditherShaped {
+			warm = max(warm, track.ditherLeadIn(from-start))
+		}
+	}
+
+	warm = min(warm, from-start)
+	if warm <= 0 {
+		return nil, nil
+	}
+
+	lead := &leadIn{warm: int(warm), taps: resampleTaps(tracks)}
+	frames, err := readRangeFrames(wavFiles, start, end, from-warm-int64(lead.taps), lead.warm+2*lead.taps)
+	if err != nil {
+		return nil, err
+	}
+
+	lead.frames = frames
+	return lead, nil
+}
+
+// ditherLeadIn returns the frames of the input before track frame pos that
+// make the output frames from the last restart of the noise shaping.
+func (t *Track) ditherLeadIn(pos int64) int64 {
+	if t.resample == nil {
+		return pos % ditherBlockFrames
 	}
 
+	out := t.resample.outputFrames(pos)
+	restart := out - out%ditherBlockFrames
+	return pos - restart*t.resample.down/t.resample.up
+}
+
+// leadInFrames returns the output frames of the track just before track
+// frame pos of the input, made from the lead-in of its input channels.
+func (t *Track) leadInFrames(lead *leadIn, pos int64) [][]float64 {
+	mixed := t.mix(channelFrames(lead.frames, t.Channels))
+
+	frames := make([][]float64, len(mixed))
+	if t.resample == nil {
+		for ch := range frames {
+			frames[ch] = append([]float64(nil), mixed[ch][lead.taps:lead.taps+lead.warm]...)
+		}
+	} else {
+		// with the filter taps of context on both sides
+		offset := lead.taps - t.resample.taps
+		resampler := t.resample.newResampler(len(mixed), pos-int64(lead.warm), pos)
+		for ch := range frames {
+			frames[ch] = make([]float64, resampler.end-resampler.next)
+			mixed[ch] = mixed[ch][offset : len(mixed[ch])-offset]
+		}
+		resampler.push(mixed)
+		resampler.pull(frames)
+	}
+
+	if t.limit {
+		for _, samples := range frames {
+			for i, v := range samples {
+				samples[i] = limitSample(v)
+			}
+		}
+	}
+
+	return frames
+}
+
+// extractTracks writes frames [startFrame, endFrame) of the wav file, which
+// starts at timeline frame filePos, to the tracks from track frame tracksPos,
+// given the edges and lead-in of the frames around them.
+func extractTracks(ctx context.Context, wavFile *WavFile, filePos int64, tracks []*Track, bufPool *sync.Pool, bytesProcessed *atomic.Int64, tracksPos, startFrame, endFrame int64, edges [2][][]float64, lead *leadIn) error {
+	bytesPerSample := wavFile.BitsPerSample / 8
+
+	// tracks may use larger samples than the input
 	trackBuffers := make([][]byte, len(tracks))
-	for i := range tracks {
-		trackBuffers[i] = make([]byte, wavFile.ByteRate)
+	for i, track := range tracks {
+		trackBuffers[i] = make([]byte, wavFile.ByteRate/wavFile.BlockAlign*track.blockAlign())
 	}
 
 	trackChans := make([]chan TrackWriteTask, len(tracks))
@@ -141,54 +248,160 @@ func extractTracks(ctx context.Context, wavFile *WavFile, tracks []*Track, bufPo
 		trackChans[i] = make(chan TrackWriteTask, 1)
 	}
 
+	tracksWg := sync.WaitGroup{}
+	tracksWg.Add(len(tracks))
 	for trackIndex, track := range tracks {
 		go func() {
+			defer tracksWg.Done()
+
+			trackBlockAlign := track.blockAlign()
+			trackBytesPerSample := trackBlockAlign / track.numChans()
+			buffer := trackBuffers[trackIndex]
+
+			// frame of the track to write at
+			trackFrame := tracksPos
+
+			var resampler *resampler
+			var frames [][]float64
+			if track.resample != nil {
+				resampler = track.resample.newResampler(track.numChans(), tracksPos, tracksPos+endFrame-startFrame)
+				resampler.push(track.mix(channelFrames(edges[0], track.Channels)))
+				trackFrame = resampler.next
+				frames = make([][]float64, track.numChans())
+				for ch := range frames {
+					frames[ch] = make([]float64, len(buffer)/trackBlockAlign)
+				}
+			}
+
+			var leadFrames [][]float64
+			if lead != nil {
+				leadFrames = track.leadInFrames(lead, tracksPos)
+			}
+
+			converter := track.newConverter(trackFrame)
+			if converter != nil && leadFrames != nil {
+				converter.warmUp(leadFrames)
+			}
+
+			analyzer := track.newAnalyzer(trackFrame)
+			if analyzer != nil {
+				analyzer.file, analyzer.filePos, analyzer.fileFrame = wavFile.Name, filePos, startFrame
+				if leadFrames != nil {
+					analyzer.warmUp(leadFrames)
+				}
+				defer track.addAnalysis(analyzer)
+			}
+
+			write := func(p []byte) {
+				if analyzer != nil {
+					track.measure(analyzer, p)
+				}
+
+				n, err := track.WriteAt(p, trackFrame*int64(trackBlockAlign))
+				if err != nil {
+					fmt.Printf("Failed to write %s: %v\n", track.Name, err)
+					os.Exit(1)
+				}
+
+				trackFrame += int64(n / trackBlockAlign)
+			}
+
+			// encode and write n frames of mixed or resampled samples
+			writeFrames := func(frames [][]float64, n int) {
+				for i := range n {
+					for ch := range frames {
+						v := frames[ch][i]
+						if track.limit {
+							v = limitSample(v)
+						}
+						converter.encodeSample(buffer[i*trackBlockAlign+ch*trackBytesPerSample:], v, ch)
+					}
+				}
+				write(buffer[:n*trackBlockAlign])
+			}
+
+			// write the resampled frames available so far
+			writeResampled := func() {
+				for {
+					n := resampler.pull(frames)
+					if n == 0 {
+						return
+					}
+					writeFrames(frames, n)
+				}
+			}
+
 			for task := range trackChans[trackIndex] {
 				if ctx.Err() != nil {
 					os.Exit(1)
 				}
 
-				bytesPerSample := wavFile.BitsPerSample / 8
-				trackBlockAlign := bytesPerSample * len(track.Channels)
+				if track.processed() {
+					mixed := track.mix(decodeChannels(task.Buffer[:task.BytesWritten], wavFile.BlockAlign, bytesPerSample, track.Channels, converter.decode))
+					if resampler != nil {
+						resampler.push(mixed)
+						writeResampled()
+					} else {
+						writeFrames(mixed, len(mixed[0]))
+					}
+					task.Wg.Done()
+					continue
+				}
+
 				bufSize := 0
 				for i := 0; i < task.BytesWritten; i += wavFile.BlockAlign {
 					for j, channelIndex := range track.Channels {
 						channelOffset := i + channelIndex*bytesPerSample
-						trackOffset := (i/wavFile.BlockAlign)*trackBlockAlign + j*bytesPerSample
-						bufSize += copy(trackBuffers[trackIndex][trackOffset:], task.Buffer[channelOffset:channelOffset+bytesPerSample])
+						trackOffset := (i/wavFile.BlockAlign)*trackBlockAlign + j*trackBytesPerSample
+						if converter != nil {
+							converter.convert(buffer[trackOffset:], task.Buffer[channelOffset:channelOffset+bytesPerSample], j)
+							bufSize += trackBytesPerSample
+							continue
+						}
+						bufSize += copy(buffer[trackOffset:], task.Buffer[channelOffset:channelOffset+bytesPerSample])
 					}
 				}
 
-				n, err := track.WriteAt(trackBuffers[trackIndex][:bufSize], trackPos[trackIndex])
-				if err != nil {
-					fmt.Printf("Failed to write %s: %v\n", track.Name, err)
-					os.Exit(1)
-				}
-
-				trackPos[trackIndex] += int64(n)
+				write(buffer[:bufSize])
 				task.Wg.Done()
 			}
+
+			if resampler != nil {
+				resampler.push(track.mix(channelFrames(edges[1], track.Channels)))
+				writeResampled()
+			}
 		}()
 	}
 
-	for {
+	if err := wavFile.SeekFrame(startFrame); err != nil {
+		return fmt.Errorf("failed to seek to frame %d: %v", startFrame, err)
+	}
+
+	remaining := (endFrame - startFrame) * int64(wavFile.BlockAlign)
+	for remaining > 0 {
 		if ctx.Err() != nil {
 			os.Exit(1)
 		}
 
 		buffer := bufPool.Get().([]byte)
-		defer bufPool.Put(buffer)
-		n, err := wavFile.Read(buffer)
+		n, err := io.ReadFull(wavFile, buffer[:min(int64(len(buffer)), remaining)])
 
 		if err == io.EOF {
+			bufPool.Put(buffer)
 			break
 		}
 
-		if err != nil {
+		if err != nil && err != io.ErrUnexpectedEOF {
+			bufPool.Put(buffer)
 			return fmt.Errorf("failed to read PCM data: %v", err)
 		}
 
+		// drop a partial frame at the end of a truncated file
+		n -= n % wavFile.BlockAlign
+		remaining -= int64(n)
+
 		if n == 0 {
+			bufPool.Put(buffer)
 			break
 		}
 
@@ -211,6 +424,7 @@ func extractTracks(ctx context.Context, wavFile *WavFile, tracks []*Track, bufPo
 	for i := range tracks {
 		close(trackChans[i])
 	}
+	tracksWg.Wait()
 
 	return nil
 }
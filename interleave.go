@@ -0,0 +1,368 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/calebmcelroy/wav-extract/wav"
+	"github.com/maruel/natural"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// X-LIVE cards write files of at most 4 GB, the FAT32 limit
+	xliveMaxFileSize = 1<<32 - 1
+
+	// order of the input tracks
+	orderName    = "name"
+	orderChannel = "channel"
+)
+
+// channel counts of X-LIVE recordings
+var xliveChannelCounts = []int{8, 16, 32}
+
+// channel numbers in track names written by extract: track_3L_4R, track_5
+// or an index template like 05_Kick
+var (
+	stereoNamePattern = regexp.MustCompile(`(\d+)L_(\d+)R`)
+	monoNamePattern   = regexp.MustCompile(`track_(\d+)`)
+	indexNamePattern  = regexp.MustCompile(`^(\d+)`)
+)
+
+// interleaveInput is a mono or stereo track to interleave.
+type interleaveInput struct {
+	*WavFile
+	Channels []int // zero-based channels of the interleaved files
+}
+
+// runInterleave is the interleave subcommand, it writes tracks as multichannel
+// files split like an X-LIVE recording.
+func runInterleave(args []string) {
+	flags := flag.NewFlagSet("interleave", flag.ExitOnError)
+	inputDirFlag := flags.String("in", ".", "Folder containing the mono & stereo WAV tracks")
+	outputDirFlag := flags.String("out", "", "Folder where the multichannel files will be saved")
+	forceFlag := flags.Bool("force", false, "Overwrite existing files in output folder")
+	orderFlag := flags.String("order", orderName, "Order of the tracks: name (natural sort of the file names) or channel (channel numbers in the file names, e.g. track_5)")
+	bitDepthFlag := flags.String("bit-depth", "32", "Bit depth of the files: 16, 24, 32 or 32f (X-LIVE uses 32)")
+	ditherFlag := flags.String("dither", "tpdf", "Dither when reducing the bit depth: none, tpdf or shaped")
+	fileSizeFlag := flags.String("file-size", "", "Split into files of at most this size (defaults to 4GB like X-LIVE)")
+	sessionNameFlag := flags.String("session-name", "", "Session name saved in SE_LOG.BIN (defaults to the output folder name)")
+	flags.Parse(args)
+
+	outputDir := *outputDirFlag
+	if outputDir == "" {
+		fmt.Println("Error output directory not specified. Please add parameter: --out=path/to/your/folder")
+		os.Exit(1)
+	}
+
+	if *orderFlag != orderName && *orderFlag != orderChannel {
+		fmt.Println("Error: invalid --order: expected name or channel")
+		os.Exit(1)
+	}
+
+	format, bitsPerSample, err := parseBitDepth(*bitDepthFlag)
+	if err != nil {
+		fmt.Println("Error: invalid --bit-depth:", err)
+		os.Exit(1)
+	}
+
+	dither, err := parseDither(*ditherFlag)
+	if err != nil {
+		fmt.Println("Error: invalid --dither:", err)
+		os.Exit(1)
+	}
+
+	fileSize := int64(xliveMaxFileSize)
+	if *fileSizeFlag != "" {
+		fileSize, err = parseSize(*fileSizeFlag)
+		if err == nil && fileSize > xliveMaxFileSize {
+			err = fmt.Errorf("files cannot be larger than 4GB")
+		}
+		if err != nil {
+			fmt.Println("Error: invalid --file-size:", err)
+			os.Exit(1)
+		}
+	}
+
+	files, err := getFilesWithExtension(*inputDirFlag, []string{"wav"})
+	if err != nil {
+		fmt.Printf("Error: reading input directory: %v\n", err)
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		fmt.Println("Error: no wav files found in the input directory.")
+		os.Exit(1)
+	}
+	sort.Sort(natural.StringSlice(files))
+
+	outputFiles, err := getFilesWithExtension(outputDir, []string{"wav"})
+	if !*forceFlag && err == nil && len(outputFiles) > 0 {
+		fmt.Println("Warning! Output folder already contains wav files. Add --force parameter if you want to overwrite files.")
+		os.Exit(1)
+	}
+	for _, file := range outputFiles {
+		if err := os.Remove(file); err != nil {
+			fmt.Printf("Error removing file %s: %v\n", file, err)
+			os.Exit(1)
+		}
+	}
+	os.MkdirAll(outputDir, os.ModePerm)
+
+	inputs, err := openInterleaveInputs(files, *orderFlag)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	defer func() {
+		for _, input := range inputs {
+			input.Close()
+		}
+	}()
+
+	numChans, frames, err := checkInterleaveInputs(inputs)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	sampleRate := inputs[0].SampleRate
+	if sampleRate != 44100 && sampleRate != 48000 {
+		fmt.Printf("Warning! X-LIVE records at 44.1 or 48 kHz, the tracks are %d Hz.\n", sampleRate)
+	}
+
+	fmt.Printf("Interleaving %d tracks into %d channels, %s:\n", len(inputs), numChans, formatPosition(frames, sampleRate))
+	for _, input := range inputs {
+		padding := ""
+		if input.Frames() < frames {
+			padding = fmt.Sprintf("  (padded with %s of silence)", formatPosition(frames-input.Frames(), sampleRate))
+		}
+		fmt.Printf("  %-7s %s%s\n", channelsKey(input.Channels), input.Name, padding)
+	}
+
+	fileFrames := interleaveFileFrames(fileSize, format, numChans, sampleRate, bitsPerSample)
+	takes, err := writeInterleaved(inputs, outputDir, numChans, frames, fileFrames, format, bitsPerSample, dither)
+	if err != nil {
+		fmt.Printf("\nError writing interleaved files: %v\n", err)
+		os.Exit(1)
+	}
+
+	name := *sessionNameFlag
+	if name == "" {
+		name = filepath.Base(filepath.Clean(outputDir))
+	}
+	session := &XLiveSession{Name: name, Channels: numChans, SampleRate: sampleRate, FileCount: len(takes)}
+	id, err := writeXLiveSession(filepath.Join(outputDir, xliveLogName), session, takes, time.Now())
+	if err != nil {
+		fmt.Printf("\nError writing session log: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\nWrote %d files and %s. Copy them to a folder named %08X in the X_LIVE folder of the SD card.\n", len(takes), xliveLogName, id)
+	fmt.Println("\nDone in", time.Since(StartTime))
+}
+
+// openInterleaveInputs opens the tracks and assigns their channels, one
+// after another in name order or from the channel numbers in their names.
+func openInterleaveInputs(files []string, order string) ([]*interleaveInput, error) {
+	var inputs []*interleaveInput
+	closeAll := func() {
+		for _, input := range inputs {
+			input.Close()
+		}
+	}
+
+	next := 0
+	for _, file := range files {
+		wavFiles, err := initReaders([]string{file})
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+
+		input := &interleaveInput{WavFile: wavFiles[0]}
+		inputs = append(inputs, input)
+
+		if input.NumChans > 2 {
+			closeAll()
+			return nil, fmt.Errorf("%s has %d channels, only mono & stereo tracks can be interleaved", input.Name, input.NumChans)
+		}
+
+		first := next
+		if order == orderChannel {
+			first, err = channelFromName(input.Name)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+		}
+
+		for ch := range input.NumChans {
+			input.Channels = append(input.Channels, first+ch)
+		}
+		next = first + input.NumChans
+	}
+
+	return inputs, nil
+}
+
+// channelFromName returns the zero-based first channel in a track name.
+func channelFromName(name string) (int, error) {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	for _, pattern := range []*regexp.Regexp{stereoNamePattern, monoNamePattern, indexNamePattern} {
+		if match := pattern.FindStringSubmatch(base); match != nil {
+			ch, _ := strconv.Atoi(match[1])
+			if ch >= 1 {
+				return ch - 1, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no channel number found in %s (e.g. track_5.wav or track_3L_4R.wav), use --order name", name)
+}
+
+// checkInterleaveInputs checks the tracks can be interleaved and returns the
+// number of channels and frames of the interleaved files.
+func checkInterleaveInputs(inputs []*interleaveInput) (numChans int, frames int64, err error) {
+	used := make(map[int]string)
+	maxChannel := 0
+	for _, input := range inputs {
+		if input.SampleRate != inputs[0].SampleRate {
+			return 0, 0, fmt.Errorf("sample rates don't match: %s is %d Hz, %s is %d Hz", inputs[0].Name, inputs[0].SampleRate, input.Name, input.SampleRate)
+		}
+
+		for _, ch := range input.Channels {
+			if other, ok := used[ch]; ok {
+				return 0, 0, fmt.Errorf("%s and %s are both on channel %d", other, input.Name, ch+1)
+			}
+			used[ch] = input.Name
+			maxChannel = max(maxChannel, ch+1)
+		}
+
+		frames = max(frames, input.Frames())
+	}
+
+	index := slices.IndexFunc(xliveChannelCounts, func(count int) bool { return count >= maxChannel })
+	if index < 0 {
+		return 0, 0, fmt.Errorf("%d channels are more than the %d of an X-LIVE recording", maxChannel, xliveChannelCounts[len(xliveChannelCounts)-1])
+	}
+
+	return xliveChannelCounts[index], frames, nil
+}
+
+// interleaveFileFrames returns the most frames of a file no larger than
+// fileSize, with its header.
+func interleaveFileFrames(fileSize int64, format, numChans, sampleRate, bitsPerSample int) int64 {
+	headerSize := wav.NewWriter(nil, format, numChans, sampleRate, bitsPerSample).Size()
+	return (fileSize - headerSize) / int64(numChans*bitsPerSample/8)
+}
+
+// writeInterleaved writes the tracks interleaved into files of at most
+// fileFrames frames named like X-LIVE takes and returns the frames of each.
+func writeInterleaved(inputs []*interleaveInput, dir string, numChans int, frames, fileFrames int64, format, bitsPerSample int, dither string) ([]int64, error) {
+	// dither only tracks with a longer word length, with noise of their own
+	converters := make([]*converter, len(inputs))
+	for i, input := range inputs {
+		var err error
+		converters[i], err = newConverter(input.SampleFormat(), input.BitsPerSample, format, bitsPerSample, dither, input.NumChans)
+		if err != nil {
+			return nil, err
+		}
+		converters[i].seed(i+1, 0)
+	}
+
+	bytesPerSample := bitsPerSample / 8
+	blockAlign := numChans * bytesPerSample
+	chunkFrames := inputs[0].SampleRate
+
+	buffers := make([][][]float64, len(inputs))
+	for i, input := range inputs {
+		buffers[i] = make([][]float64, input.NumChans)
+		for ch := range buffers[i] {
+			buffers[i][ch] = make([]float64, chunkFrames)
+		}
+	}
+	buf := make([]byte, chunkFrames*blockAlign)
+
+	var takes []int64
+	for start := int64(0); start < frames; start += fileFrames {
+		takeFrames := min(fileFrames, frames-start)
+		path := filepath.Join(dir, fmt.Sprintf("%08d.WAV", len(takes)+1))
+
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create output file '%s': %v", path, err)
+		}
+		w := wav.NewWriter(file, format, numChans, inputs[0].SampleRate, bitsPerSample)
+
+		for off := int64(0); off < takeFrames; off += int64(chunkFrames) {
+			n := int(min(int64(chunkFrames), takeFrames-off))
+			clear(buf[:n*blockAlign])
+
+			for i, input := range inputs {
+				read, err := readFull(input.WavFile, buffers[i], n)
+				if err != nil {
+					file.Close()
+					return nil, fmt.Errorf("failed to read %s: %v", input.Name, err)
+				}
+
+				for j, ch := range input.Channels {
+					for frame := range n {
+						v := 0.0
+						if frame < read {
+							v = buffers[i][j][frame]
+						}
+						converters[i].encodeSample(buf[frame*blockAlign+ch*bytesPerSample:], v, j)
+					}
+				}
+			}
+
+			if _, err := w.WriteAt(buf[:n*blockAlign], off*int64(blockAlign)); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+
+		if err := w.Close(); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if err := file.Close(); err != nil {
+			return nil, err
+		}
+
+		takes = append(takes, takeFrames)
+		fmt.Printf("\r%d%% %s", (start+takeFrames)*100/frames, filepath.Base(path))
+	}
+
+	return takes, nil
+}
+
+// readFull reads up to n frames into dst, fewer at the end of the file.
+func readFull(wavFile *WavFile, dst [][]float64, n int) (int, error) {
+	read := 0
+	for read < n {
+		frames := make([][]float64, len(dst))
+		for ch := range dst {
+			frames[ch] = dst[ch][read:n]
+		}
+
+		got, err := wavFile.ReadFrames(frames)
+		read += got
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return read, err
+		}
+		if got == 0 {
+			break
+		}
+	}
+	return read, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/calebmcelroy/wav-extract/wav"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// xliveFixtureTime is the start of the session in testdata/xlive/SE_LOG.BIN
+var xliveFixtureTime = time.Date(2024, 3, 17, 10, 30, 12, 0, time.UTC)
+
+func TestChannelFromName(t *testing.T) {
+	tests := []struct {
+		name string
+		ch   int // -1 for an error
+	}{
+		{"track_5.wav", 4},
+		{"track_12.WAV", 11},
+		{"track_3L_4R.wav", 2},
+		{"Keys_11L_12R.wav", 10},
+		{"05_Kick.wav", 4},
+		{"12_track_3.wav", 2},
+		{"07.wav", 6},
+		{"Kick.wav", -1},
+		{"track_0.wav", -1},
+		{"00_Kick.wav", -1},
+		{"Kick_05.wav", -1},
+	}
+
+	for _, test := range tests {
+		ch, err := channelFromName(test.name)
+		if test.ch < 0 {
+			if err == nil {
+				t.Errorf("channelFromName(%q) = %d, want an error", test.name, ch)
+			}
+			continue
+		}
+		if err != nil || ch != test.ch {
+			t.Errorf("channelFromName(%q) = %d, %v, want %d", test.name, ch, err, test.ch)
+		}
+	}
+}
+
+func TestCheckInterleaveInputs(t *testing.T) {
+	input := func(name string, sampleRate int, frames int, channels ...int) *interleaveInput {
+		reader := &wav.Reader{NumChans: len(channels), SampleRate: sampleRate, BlockAlign: 1, DataSize: frames}
+		return &interleaveInput{WavFile: &WavFile{Reader: reader, Name: name}, Channels: channels}
+	}
+
+	tests := []struct {
+		inputs   []*interleaveInput
+		numChans int
+		frames   int64
+		err      string
+	}{
+		{[]*interleaveInput{input("a.wav", 48000, 100, 0)}, 8, 100, ""},
+		{[]*interleaveInput{input("a.wav", 48000, 100, 0), input("b.wav", 48000, 300, 6, 7)}, 8, 300, ""},
+		{[]*interleaveInput{input("a.wav", 48000, 300, 8)}, 16, 300, ""},
+		{[]*interleaveInput{input("a.wav", 44100, 300, 14, 15), input("b.wav", 44100, 100, 16)}, 32, 300, ""},
+		{[]*interleaveInput{input("a.wav", 48000, 100, 32)}, 0, 0, "33 channels are more than the 32"},
+		{[]*interleaveInput{input("a.wav", 48000, 100, 0), input("b.wav", 44100, 100, 1)}, 0, 0, "sample rates don't match"},
+		{[]*interleaveInput{input("a.wav", 48000, 100, 0, 1), input("b.wav", 48000, 100, 1)}, 0, 0, "a.wav and b.wav are both on channel 2"},
+	}
+
+	for i, test := range tests {
+		numChans, frames, err := checkInterleaveInputs(test.inputs)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("inputs %d: error = %v, want %q", i, err, test.err)
+			}
+			continue
+		}
+		if err != nil || numChans != test.numChans || frames != test.frames {
+			t.Errorf("inputs %d: %d channels of %d frames, %v, want %d of %d", i, numChans, frames, err, test.numChans, test.frames)
+		}
+	}
+}
+
+// A mono and a shorter stereo track are interleaved into files split at the
+// size limit, channels without a track and the end of the stereo track are
+// silent.
+func TestWriteInterleaved(t *testing.T) {
+	const sampleRate = 48000
+
+	inputDir, outputDir := t.TempDir(), t.TempDir()
+	mono := [][]float64{make([]float64, 1000)}
+	for i := range mono[0] {
+		mono[0][i] = float64(i)/1000 - 0.5
+	}
+	stereo := [][]float64{make([]float64, 600), make([]float64, 600)}
+	for i := range stereo[0] {
+		stereo[0][i], stereo[1][i] = 0.25, -0.75
+	}
+
+	files := []string{filepath.Join(inputDir, "track_1.wav"), filepath.Join(inputDir, "track_3L_4R.wav")}
+	writeTestWav(t, files[0], wav.FormatIEEEFloat, 32, sampleRate, mono)
+	writeTestWav(t, files[1], wav.FormatIEEEFloat, 32, sampleRate, stereo)
+	inputs, err := openInterleaveInputs(files, orderChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, input := range inputs {
+			input.Close()
+		}
+	}()
+
+	numChans, frames, err := checkInterleaveInputs(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// files of 300 frames and a few bytes to spare
+	fileSize := wav.NewWriter(nil, wav.FormatPCM, numChans, sampleRate, 32).Size() + 300*int64(numChans)*4 + 31
+	fileFrames := interleaveFileFrames(fileSize, wav.FormatPCM, numChans, sampleRate, 32)
+	takes, err := writeInterleaved(inputs, outputDir, numChans, frames, fileFrames, wav.FormatPCM, 32, ditherTPDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{300, 300, 300, 100}; !reflect.DeepEqual(takes, want) {
+		t.Fatalf("takes = %v, want %v", takes, want)
+	}
+
+	for i := range takes {
+		path := filepath.Join(outputDir, fmt.Sprintf("%08d.WAV", i+1))
+		if info, err := os.Stat(path); err != nil || info.Size() > fileSize {
+			t.Fatalf("%s is larger than %d bytes: %v", filepath.Base(path), fileSize, err)
+		}
+
+		out, _ := readTestWav(t, path)
+		for j := range out[0] {
+			frame := i*300 + j
+			want := make([]float64, numChans)
+			want[0] = mono[0][frame]
+			if frame < len(stereo[0]) {
+				want[2], want[3] = stereo[0][frame], stereo[1][frame]
+			}
+			for ch := range want {
+				if math.Abs(out[ch][j]-want[ch]) > 1e-7 {
+					t.Fatalf("frame %d of channel %d = %g, want %g", frame, ch+1, out[ch][j], want[ch])
+				}
+			}
+		}
+	}
+}
+
+func TestWriteXLiveSession(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "xlive", xliveLogName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := &XLiveSession{
+		Name:       "Sunday Service",
+		Channels:   32,
+		SampleRate: 48000,
+		Markers:    []Marker{{480000, ""}, {34000000, ""}, {70000000, ""}},
+	}
+
+	tests := []struct {
+		takes []int64
+		want  []byte // nil for an error
+	}{
+		{[]int64{33554431, 33554431, 12000000}, fixture},
+		{[]int64{math.MaxUint32, 1}, nil},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), xliveLogName)
+		id, err := writeXLiveSession(path, session, test.takes, xliveFixtureTime)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("takes %v: wrote a session log, want an error", test.takes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if id != 0x587153C6 {
+			t.Errorf("session id = %08X, want 587153C6", id)
+		}
+		if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, test.want) {
+			t.Errorf("session log differs from testdata/xlive/SE_LOG.BIN: %v", err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"github.com/calebmcelroy/wav-extract/wav"
+	"strconv"
+	"strings"
+)
+
+// Layout is the speaker layout of a multichannel track, e.g. 5.1.
+type Layout struct {
+	Name   string
+	Labels []string // label of each channel, used in the track name
+	Mask   int      // WAVE_FORMAT_EXTENSIBLE channel mask, 0 without speaker positions
+}
+
+// channel order of the layouts follows the WAVE_FORMAT_EXTENSIBLE order
+var layouts = []Layout{
+	{"lcr", []string{"L", "R", "C"}, wav.SpeakerFrontLeft | wav.SpeakerFrontRight | wav.SpeakerFrontCenter},
+	{"quad", []string{"L", "R", "Ls", "Rs"}, wav.SpeakerFrontLeft | wav.SpeakerFrontRight | wav.SpeakerBackLeft | wav.SpeakerBackRight},
+	{"5.0", []string{"L", "R", "C", "Ls", "Rs"}, wav.SpeakerFrontLeft | wav.SpeakerFrontRight | wav.SpeakerFrontCenter | wav.SpeakerBackLeft | wav.SpeakerBackRight},
+	{"5.1", []string{"L", "R", "C", "LFE", "Ls", "Rs"}, wav.SpeakerFrontLeft | wav.SpeakerFrontRight | wav.SpeakerFrontCenter | wav.SpeakerLowFrequency | wav.SpeakerBackLeft | wav.SpeakerBackRight},
+	{"7.1", []string{"L", "R", "C", "LFE", "Lrs", "Rrs", "Lss", "Rss"}, wav.SpeakerFrontLeft | wav.SpeakerFrontRight | wav.SpeakerFrontCenter | wav.SpeakerLowFrequency | wav.SpeakerBackLeft | wav.SpeakerBackRight | wav.SpeakerSideLeft | wav.SpeakerSideRight},
+}
+
+// isLayout returns whether name is a layout modifier.
+func isLayout(name string) bool {
+	if name == "ambix" {
+		return true
+	}
+	for _, layout := range layouts {
+		if layout.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getLayout returns the layout called name for a group of numChans channels.
+// Ambisonic (ambix, ACN order) groups have (order+1)² channels and no speaker
+// positions.
+func getLayout(name string, numChans int) (*Layout, error) {
+	if name == "ambix" {
+		order := 1
+		for (order+1)*(order+1) < numChans {
+			order++
+		}
+		if (order+1)*(order+1) != numChans {
+			return nil, fmt.Errorf("ambix needs 4, 9, 16... channels, got %d", numChans)
+		}
+
+		labels := []string{"W", "Y", "Z", "X"}
+		for acn := 4; acn < numChans; acn++ {
+			labels = append(labels, "ACN"+strconv.Itoa(acn))
+		}
+		return &Layout{Name: name, Labels: labels}, nil
+	}
+
+	for _, layout := range layouts {
+		if layout.Name != name {
+			continue
+		}
+		if len(layout.Labels) != numChans {
+			return nil, fmt.Errorf("%s needs %d channels (%s), got %d", name, len(layout.Labels), strings.Join(layout.Labels, " "), numChans)
+		}
+		return &layout, nil
+	}
+
+	return nil, fmt.Errorf("unknown layout %s", name)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"github.com/calebmcelroy/wav-extract/wav"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetLayout(t *testing.T) {
+	for _, layout := range layouts {
+		got, err := getLayout(layout.Name, len(layout.Labels))
+		if err != nil {
+			t.Errorf("getLayout(%s, %d): %v", layout.Name, len(layout.Labels), err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, layout) {
+			t.Errorf("getLayout(%s) = %+v, want %+v", layout.Name, *got, layout)
+		}
+		// a speaker position for every channel
+		if bits.OnesCount(uint(layout.Mask)) != len(layout.Labels) {
+			t.Errorf("%s mask %#x has %d speakers, want %d", layout.Name, layout.Mask, bits.OnesCount(uint(layout.Mask)), len(layout.Labels))
+		}
+		if !isLayout(layout.Name) {
+			t.Errorf("isLayout(%s) = false", layout.Name)
+		}
+	}
+
+	want := []string{"W", "Y", "Z", "X", "ACN4", "ACN5", "ACN6", "ACN7", "ACN8", "ACN9", "ACN10", "ACN11", "ACN12", "ACN13", "ACN14", "ACN15"}
+	for _, numChans := range []int{4, 9, 16} {
+		layout, err := getLayout("ambix", numChans)
+		if err != nil {
+			t.Errorf("getLayout(ambix, %d): %v", numChans, err)
+			continue
+		}
+		if !reflect.DeepEqual(layout.Labels, want[:numChans]) || layout.Mask != 0 {
+			t.Errorf("ambix with %d channels = %+v", numChans, layout)
+		}
+	}
+
+	for _, test := range []struct {
+		name     string
+		numChans int
+		err      string
+	}{
+		{"5.1", 5, "5.1 needs 6 channels (L R C LFE Ls Rs), got 5"},
+		{"7.1", 6, "7.1 needs 8 channels (L R C LFE Lrs Rrs Lss Rss), got 6"},
+		{"lcr", 2, "lcr needs 3 channels (L R C), got 2"},
+		{"quad", 5, "quad needs 4 channels (L R Ls Rs), got 5"},
+		{"ambix", 3, "ambix needs 4, 9, 16... channels, got 3"},
+		{"ambix", 8, "ambix needs 4, 9, 16... channels, got 8"},
+		{"ambix", 10, "ambix needs 4, 9, 16... channels, got 10"},
+		{"6.1", 7, "unknown layout 6.1"},
+	} {
+		if _, err := getLayout(test.name, test.numChans); err == nil || err.Error() != test.err {
+			t.Errorf("getLayout(%s, %d) error = %v, want %q", test.name, test.numChans, err, test.err)
+		}
+	}
+	if isLayout("6.1") || isLayout("pairs") {
+		t.Error("isLayout accepts modifiers that are not layouts")
+	}
+}
+
+// Groups are written as WAVE_FORMAT_EXTENSIBLE with the speaker positions of
+// their layout and named by the label of each channel.
+func TestLayoutTracks(t *testing.T) {
+	const numChans = 16
+
+	frames := make([][]float64, numChans)
+	for ch := range frames {
+		frames[ch] = make([]float64, 1000)
+		for i := range frames[ch] {
+			frames[ch][i] = float64(ch+1) / 32
+		}
+	}
+
+	opts := testFloatTrackOptions(t.TempDir(), 1000)
+	tracks, err := initTracks("", "1-6:5.1,7-10:ambix,11/12,13/14/15,16", nil, numChans, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractTestTracks(t, openTestWavs(t, 48000, frames, 1000), tracks)
+
+	tests := []struct {
+		name     string
+		channels []int
+		format   int
+		mask     int
+	}{
+		{"track_1L_2R_3C_4LFE_5Ls_6Rs", []int{0, 1, 2, 3, 4, 5}, wav.FormatExtensible, 0x3F},
+		{"track_7W_8Y_9Z_10X", []int{6, 7, 8, 9}, wav.FormatExtensible, 0},
+		{"track_11L_12R", []int{10, 11}, wav.FormatIEEEFloat, 0},
+		{"track_13_14_15", []int{12, 13, 14}, wav.FormatExtensible, 0},
+		{"track_16", []int{15}, wav.FormatIEEEFloat, 0},
+	}
+	if len(tracks) != len(tests) {
+		t.Fatalf("%d tracks, want %d", len(tracks), len(tests))
+	}
+
+	for i, test := range tests {
+		if tracks[i].Name != test.name {
+			t.Errorf("track %d is named %s, want %s", i+1, tracks[i].Name, test.name)
+		}
+
+		path := filepath.Join(opts.OutputDir, test.name+".wav")
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := wav.NewReader(file)
+		err = r.ReadHeader()
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.AudioFormat != test.format || r.ChannelMask != test.mask || r.SampleFormat() != wav.FormatIEEEFloat {
+			t.Errorf("%s format %#x, mask %#x, want %#x, mask %#x", test.name, r.AudioFormat, r.ChannelMask, test.format, test.mask)
+		}
+
+		// the channels keep their order
+		out, _ := readTestWav(t, path)
+		for j, ch := range test.channels {
+			if out[j][0] != frames[ch][0] {
+				t.Errorf("%s channel %d = %g, want input channel %d", test.name, j+1, out[j][0], ch+1)
+			}
+		}
+	}
+
+	// the channel count of a layout is checked where it is given
+	for _, str := range []string{"1-5:5.1", "1-8:ambix", "1/2/3/4:lcr", "1-7:7.1"} {
+		_, err := initTracks("", str, nil, numChans, opts)
+		if err == nil || !strings.Contains(err.Error(), "needs") {
+			t.Errorf("initTracks(%q) error = %v, want a channel count error", str, err)
+		}
+	}
+}
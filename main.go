@@ -17,11 +17,39 @@ import (
 var StartTime = time.Now()
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "interleave" {
+		runInterleave(os.Args[2:])
+		return
+	}
+
 	inputDirFlag := flag.String("in", ".", "Folder containing input WAV files")
 	outputDirFlag := flag.String("out", "", "Folder where output files will be saved")
 	forceFlag := flag.Bool("force", false, "Overwrite existing files in output folder")
 	stereoFlag := flag.String("stereo", "", "Stereo pairs to extract (e.g. 1/2,3/4)")
-	channelsFlag := flag.String("channels", "", "Channels to extract (e.g. 1/2,5)")
+	channelsFlag := flag.String("channels", "", "Channels to extract (e.g. 1-8,9/10:ms,11:-6dB,17-24:pairs,all,!25-32)")
+	startFlag := flag.String("start", "", "Position to start extracting at (e.g. 01:30:00.000, 90s, 4320000smp)")
+	endFlag := flag.String("end", "", "Position to stop extracting at (e.g. 01:50:00.000, 6600s, 316800000smp)")
+	segmentLengthFlag := flag.String("segment-length", "", "Split tracks into files of this length (e.g. 01:00:00, 1800s)")
+	segmentSizeFlag := flag.String("segment-size", "", "Split tracks into files of at most this size (e.g. 2G, 700MB)")
+	markersFlag := flag.String("markers", "", "CSV file with markers to add (position,label)")
+	splitOnMarkersFlag := flag.Bool("split-on-markers", false, "Split tracks into a folder per marker")
+	namesFlag := flag.String("names", "", "Track names (e.g. 1=Kick,2=Snare,3/4=Keys) or a .csv/.json file with names")
+	nameTemplateFlag := flag.String("name-template", "", "Output file name template (e.g. {index:02}_{name}.wav)")
+	sceneFlag := flag.String("scene", "", "X32/M32 scene file (.scn) to name tracks and find stereo pairs")
+	autoStereoFlag := flag.Bool("auto-stereo", false, "Detect stereo pairs by comparing adjacent channels")
+	yesFlag := flag.Bool("yes", false, "Use detected stereo pairs without asking")
+	bitDepthFlag := flag.String("bit-depth", "", "Bit depth of the tracks: 16, 24, 32 or 32f (defaults to the input bit depth)")
+	sampleRateFlag := flag.Int("sample-rate", 0, "Sample rate of the tracks in Hz, e.g. 44100 (defaults to the input sample rate)")
+	ditherFlag := flag.String("dither", "tpdf", "Dither when reducing the bit depth: none, tpdf or shaped")
+	reportFlag := flag.Bool("report", false, "Measure the levels & loudness of the tracks and save report.json & report.csv")
+	detectEventsFlag := flag.Bool("detect-events", false, "Find clipping & dropouts and save them to events.csv")
+	eventMarkersFlag := flag.Bool("event-markers", false, "Add clipping & dropouts as cue points to the tracks (implies --detect-events)")
+	delayFlag := flag.String("delay", "", "Delay channels by an offset to align them, the channels of a stereo track or bus need the same offset (e.g. 12=+3.2ms,13=-48smp)")
+	autoAlignFlag := flag.String("auto-align", "", "Align tracks to a reference channel by cross-correlation (e.g. ref=1)")
+	var busFlags busFlag
+	flag.Var(&busFlags, "bus", "Mix channels into an extra track, can be repeated (e.g. Drums=1,2,3:-3dB,4:L50:stereo)")
+	var skipSilentFlag silenceFlag
+	flag.Var(&skipSilentFlag, "skip-silent", "Remove tracks peaking below a level (default -80dBFS, e.g. --skip-silent=-70dBFS)")
 	flag.Parse()
 
 	inputDir := *inputDirFlag
@@ -34,7 +62,7 @@ func main() {
 	}
 
 	// throw error if output directory contains wav files
-	outputDirFiles, err := getFilesWithExtension(outputDir, []string{"wav"})
+	outputDirFiles, err := getOutputFiles(outputDir)
 
 	if !force && err == nil && len(outputDirFiles) > 0 {
 		fmt.Println("Warning! Output folder already contains wav files. Add --force parameter if you want to overwrite files.")
@@ -42,12 +70,9 @@ func main() {
 	}
 
 	if force {
-		for _, file := range outputDirFiles {
-			err := os.Remove(file)
-			if err != nil {
-				fmt.Printf("Error removing file %s: %v\n", file, err)
-				os.Exit(1)
-			}
+		if err := removeOutputFiles(outputDir, outputDirFiles); err != nil {
+			fmt.Printf("Error removing %v\n", err)
+			os.Exit(1)
 		}
 	}
 
@@ -67,6 +92,21 @@ func main() {
 	}
 
 	sort.Sort(natural.StringSlice(files))
+
+	session, err := findXLiveSession(inputDir)
+	if err != nil {
+		fmt.Printf("Error reading X-LIVE session log: %v\n", err)
+		os.Exit(1)
+	}
+
+	if session != nil {
+		fmt.Printf("X-LIVE session %q: %d files, %d markers\n", session.Name, session.FileCount, len(session.Markers))
+
+		if session.FileCount != len(files) {
+			fmt.Printf("Warning! X-LIVE session log lists %d files but %d were found, markers may be misplaced.\n", session.FileCount, len(files))
+		}
+	}
+
 	os.MkdirAll(outputDir, os.ModePerm)
 
 	wavFiles, err := initReaders(files)
@@ -82,8 +122,178 @@ func main() {
 		}
 	}()
 
+	if session != nil {
+		if err := session.checkFiles(wavFiles); err != nil {
+			fmt.Println("Error reading X-LIVE session log:", err)
+			os.Exit(1)
+		}
+	}
+
 	wavFile := wavFiles[0]
-	tracks, err := initTracks(*stereoFlag, *channelsFlag, outputDir, wavFile.NumChans, wavFile.BitsPerSample, wavFile.SampleRate)
+	start, end, err := parseRange(*startFlag, *endFlag, wavFile.SampleRate, timelineFrames(wavFiles))
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	trackOpts := TrackOptions{
+		OutputDir:           outputDir,
+		AudioFormat:         wavFile.SampleFormat(),
+		SampleRate:          wavFile.SampleRate,
+		BitsPerSample:       wavFile.BitsPerSample,
+		SourceFormat:        wavFile.SampleFormat(),
+		SourceBitsPerSample: wavFile.BitsPerSample,
+		SourceSampleRate:    wavFile.SampleRate,
+		Levels:              skipSilentFlag.enabled,
+		DetectEvents:        *detectEventsFlag || *eventMarkersFlag,
+		Report:              *reportFlag,
+	}
+
+	if *sampleRateFlag != 0 {
+		if *sampleRateFlag < 8000 || *sampleRateFlag > 384000 {
+			fmt.Println("Error: invalid --sample-rate: must be between 8000 and 384000 Hz")
+			os.Exit(1)
+		}
+		trackOpts.SampleRate = *sampleRateFlag
+	}
+
+	if *bitDepthFlag != "" {
+		trackOpts.AudioFormat, trackOpts.BitsPerSample, err = parseBitDepth(*bitDepthFlag)
+		if err != nil {
+			fmt.Println("Error: invalid --bit-depth:", err)
+			os.Exit(1)
+		}
+	}
+
+	trackOpts.Dither, err = parseDither(*ditherFlag)
+	if err != nil {
+		fmt.Println("Error: invalid --dither:", err)
+		os.Exit(1)
+	}
+
+	markers, err := collectMarkers(wavFiles, session, *markersFlag, wavFile.SampleRate)
+	if err != nil {
+		fmt.Println("Error reading markers:", err)
+		os.Exit(1)
+	}
+	trackOpts.Markers = markersInRange(markers, start, end)
+	for i := range trackOpts.Markers {
+		trackOpts.Markers[i].Position = convertFrames(trackOpts.Markers[i].Position, wavFile.SampleRate, trackOpts.SampleRate)
+	}
+
+	trackOpts.Names, err = parseChannelNames(*namesFlag)
+	if err != nil {
+		fmt.Println("Error: invalid --names:", err)
+		os.Exit(1)
+	}
+
+	stereoStr := *stereoFlag
+	if *sceneFlag != "" {
+		scene, err := readX32Scene(*sceneFlag)
+		if err != nil {
+			fmt.Println("Error reading scene:", err)
+			os.Exit(1)
+		}
+
+		// names & stereo pairs given on the command line take precedence
+		sceneNames, scenePairs := scene.tracks(wavFile.NumChans)
+		if trackOpts.Names == nil {
+			trackOpts.Names = make(ChannelNames)
+		}
+		for key, name := range sceneNames {
+			if _, ok := trackOpts.Names[key]; !ok {
+				trackOpts.Names[key] = name
+			}
+		}
+
+		if stereoStr == "" && *channelsFlag == "" && scenePairs != "" {
+			fmt.Println("Stereo pairs from scene:", scenePairs)
+			stereoStr = scenePairs
+		}
+	}
+
+	if *autoStereoFlag {
+		if *stereoFlag != "" || *channelsFlag != "" {
+			fmt.Println("Error: --auto-stereo cannot be combined with --stereo or --channels")
+			os.Exit(1)
+		}
+
+		pairs, err := detectStereoPairs(wavFiles, start, end)
+		if err != nil {
+			fmt.Println("Error detecting stereo pairs:", err)
+			os.Exit(1)
+		}
+
+		stereoStr = confirmStereoPairs(pairs, os.Stdin, *yesFlag)
+	}
+
+	trackOpts.NameTemplate = *nameTemplateFlag
+	if session != nil {
+		trackOpts.Session = sanitizeFileName(session.Name)
+		if trackOpts.NameTemplate == "" && trackOpts.Session != "" {
+			trackOpts.NameTemplate = "{session}_" + defaultNameTemplate
+		}
+	}
+
+	if *splitOnMarkersFlag {
+		if *segmentLengthFlag != "" || *segmentSizeFlag != "" {
+			fmt.Println("Error: --split-on-markers cannot be combined with --segment-length or --segment-size")
+			os.Exit(1)
+		}
+
+		if len(trackOpts.Markers) == 0 {
+			fmt.Println("Error: no markers found to split on. Markers are read from the cue points of the input files, the X-LIVE session log or --markers.")
+			os.Exit(1)
+		}
+
+		trackOpts.Splits = markerSplits(trackOpts.Markers)
+	}
+
+	if *segmentLengthFlag != "" && *segmentSizeFlag != "" {
+		fmt.Println("Error: both --segment-length and --segment-size cannot be specified, choose just one")
+		os.Exit(1)
+	}
+
+	if *segmentLengthFlag != "" {
+		trackOpts.SegmentFrames, err = parsePosition(*segmentLengthFlag, trackOpts.SampleRate)
+		if err == nil && trackOpts.SegmentFrames == 0 {
+			err = fmt.Errorf("segment length must be greater than 0")
+		}
+		if err != nil {
+			fmt.Println("Error: invalid --segment-length:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *segmentSizeFlag != "" {
+		trackOpts.SegmentSize, err = parseSize(*segmentSizeFlag)
+		if err != nil {
+			fmt.Println("Error: invalid --segment-size:", err)
+			os.Exit(1)
+		}
+	}
+
+	trackOpts.SourceFrames = end - start
+	if *delayFlag != "" {
+		trackOpts.Delays, err = parseDelays(*delayFlag, wavFile.NumChans, wavFile.SampleRate, trackOpts.SampleRate)
+		if err != nil {
+			fmt.Println("Error: invalid --delay:", err)
+			os.Exit(1)
+		}
+	}
+
+	var buses []Bus
+	for _, busStr := range busFlags {
+		bus, err := parseBus(busStr, wavFile.NumChans)
+		if err != nil {
+			fmt.Println("Error: invalid --bus:", err)
+			os.Exit(1)
+		}
+		buses = append(buses, bus)
+	}
+
+	tracks, err := initTracks(stereoStr, *channelsFlag, buses, wavFile.NumChans, trackOpts)
 
 	if err != nil {
 		fmt.Println("Error initializing tracks:", err)
@@ -96,6 +306,26 @@ func main() {
 		}
 	}()
 
+	if *autoAlignFlag != "" {
+		ref, maxLag, err := parseAutoAlign(*autoAlignFlag, wavFile.NumChans, wavFile.SampleRate)
+		if err != nil {
+			fmt.Println("Error: invalid --auto-align:", err)
+			os.Exit(1)
+		}
+
+		alignments, err := estimateAlignments(wavFiles, tracks, ref, maxLag, start, end)
+		if err != nil {
+			fmt.Println("Error aligning tracks:", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Auto alignment to channel %d:\n", ref+1)
+		for _, alignment := range alignments {
+			fmt.Println("  " + alignment.String(wavFile.SampleRate))
+		}
+		applyAlignments(alignments, trackOpts.Delays, wavFile.SampleRate, trackOpts.SampleRate)
+	}
+
 	defer func() {
 		fmt.Println("\n\nDone in", time.Since(StartTime))
 	}()
@@ -103,7 +333,83 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT)
 	defer cancel()
 
-	extract(ctx, wavFiles, tracks, time.Millisecond*500, printProgress)
+	extract(ctx, wavFiles, tracks, start, end, time.Millisecond*500, printProgress)
+
+	for _, track := range tracks {
+		if err := track.padDelay(); err != nil {
+			fmt.Printf("\nError padding %s: %v\n", track.Name, err)
+			os.Exit(1)
+		}
+	}
+
+	for _, track := range tracks {
+		if err := track.normalizeTrack(); err != nil {
+			fmt.Printf("\nError normalizing %s: %v\n", track.Name, err)
+			os.Exit(1)
+		}
+	}
+
+	if skipSilentFlag.enabled {
+		var removed []*Track
+		tracks, removed, err = removeSilentTracks(tracks, skipSilentFlag.level)
+		if err != nil {
+			fmt.Printf("\nError removing silent tracks: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(removed) > 0 {
+			fmt.Printf("\n\nRemoved %d silent tracks (peak below %s):\n", len(removed), skipSilentFlag.String())
+			for _, track := range removed {
+				fmt.Printf("  %-7s %-20s peak %.1f dBFS\n", channelsKey(track.Channels), track.Name, track.Peak())
+			}
+		} else {
+			fmt.Printf("\n\nNo silent tracks found (peak below %s).\n", skipSilentFlag.String())
+		}
+	}
+
+	if *reportFlag {
+		reports := trackReports(tracks)
+		fmt.Print("\n\n")
+		printReport(reports)
+
+		if err := writeReport(outputDir, reports); err != nil {
+			fmt.Printf("\nError writing report: %v\n", err)
+		}
+	}
+
+	if *detectEventsFlag || *eventMarkersFlag {
+		var events []Event
+		for _, track := range tracks {
+			trackEvents := track.Events()
+			events = append(events, trackEvents...)
+
+			if *eventMarkersFlag {
+				markers := make([]Marker, len(trackEvents))
+				for i, event := range trackEvents {
+					markers[i] = Marker{event.Start, event.Label()}
+				}
+				track.AddMarkers(markers)
+			}
+		}
+
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].Position < events[j].Position
+		})
+
+		fmt.Print("\n\n")
+		printEvents(events, wavFile.SampleRate)
+
+		if err := writeEvents(filepath.Join(outputDir, "events.csv"), events, wavFile.SampleRate); err != nil {
+			fmt.Printf("\nError writing events: %v\n", err)
+		}
+	}
+
+	if trackOpts.SegmentFrames > 0 || trackOpts.SegmentSize > 0 || len(trackOpts.Splits) > 0 {
+		err = writeSegmentIndex(filepath.Join(outputDir, "segments.csv"), tracks, start, wavFile.SampleRate)
+		if err != nil {
+			fmt.Printf("\nError writing segment index: %v\n", err)
+		}
+	}
 }
 
 func printProgress(p Progress) {
@@ -135,6 +441,59 @@ func printProgress(p Progress) {
 	fmt.Printf("\r%d%% [%s] %dGB / %dGB (%d MB/s) — %v remaining", percent, progressStr, processedGB, totalGB, bytesPerSecond/1024/1024, timeRemaining)
 }
 
+// getOutputFiles returns the wav files in the output folder and in its
+// subfolders, e.g. the folders of --split-on-markers.
+func getOutputFiles(dir string) ([]string, error) {
+	files, err := getFilesWithExtension(dir, []string{"wav"})
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		subFiles, err := getFilesWithExtension(filepath.Join(dir, entry.Name()), []string{"wav"})
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, subFiles...)
+	}
+
+	return files, nil
+}
+
+// removeOutputFiles removes files found by getOutputFiles and the subfolders
+// of dir they leave empty.
+func removeOutputFiles(dir string, files []string) error {
+	folders := make(map[string]bool)
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			return fmt.Errorf("file %s: %v", file, err)
+		}
+		if folder := filepath.Dir(file); folder != filepath.Clean(dir) {
+			folders[folder] = true
+		}
+	}
+
+	for folder := range folders {
+		entries, err := os.ReadDir(folder)
+		if err == nil && len(entries) == 0 {
+			if err := os.Remove(folder); err != nil {
+				return fmt.Errorf("folder %s: %v", folder, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 func getFilesWithExtension(dir string, extensions []string) ([]string, error) {
 	// case insensitive
 
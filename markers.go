@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/calebmcelroy/wav-extract/wav"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Marker is a labelled position, in frames.
+type Marker struct {
+	Position int64
+	Label    string
+}
+
+// collectMarkers gathers the markers on the timeline from the cue chunks of
+// the wav files, the X-LIVE session log and a marker CSV file. Markers are
+// sorted and markers at the same position are merged.
+func collectMarkers(wavFiles []*WavFile, session *XLiveSession, csvPath string, sampleRate int) ([]Marker, error) {
+	var markers []Marker
+
+	wavFilePositions := timelinePositions(wavFiles)
+	for i, wavFile := range wavFiles {
+		cues, err := wavFile.CuePoints()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cue points (%s): %v", wavFile.Name, err)
+		}
+
+		for _, cue := range cues {
+			markers = append(markers, Marker{wavFilePositions[i] + cue.Position, cue.Label})
+		}
+	}
+
+	if session != nil {
+		markers = append(markers, session.Markers...)
+	}
+
+	if csvPath != "" {
+		csvMarkers, err := readMarkersCSV(csvPath, sampleRate)
+		if err != nil {
+			return nil, err
+		}
+		markers = append(markers, csvMarkers...)
+	}
+
+	sort.SliceStable(markers, func(i, j int) bool {
+		return markers[i].Position < markers[j].Position
+	})
+
+	var merged []Marker
+	for _, marker := range markers {
+		if len(merged) > 0 && merged[len(merged)-1].Position == marker.Position {
+			if merged[len(merged)-1].Label == "" {
+				merged[len(merged)-1].Label = marker.Label
+			}
+			continue
+		}
+		merged = append(merged, marker)
+	}
+
+	for i := range merged {
+		if merged[i].Label == "" {
+			merged[i].Label = fmt.Sprintf("Marker %d", i+1)
+		}
+	}
+
+	return merged, nil
+}
+
+// readMarkersCSV reads markers from a CSV file with a position and a label
+// column, e.g. "00:05:30,Song 1". Positions use the --start format and a
+// header row is skipped.
+func readMarkersCSV(path string, sampleRate int) ([]Marker, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var markers []Marker
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid marker file %s: %v", path, err)
+		}
+
+		if len(record) == 0 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		position, err := parsePosition(record[0], sampleRate)
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("invalid marker file %s, line %d: %v", path, line, err)
+		}
+
+		label := ""
+		if len(record) > 1 {
+			label = strings.TrimSpace(record[1])
+		}
+
+		markers = append(markers, Marker{position, label})
+	}
+
+	return markers, nil
+}
+
+// markersInRange returns the markers within timeline frames [start, end),
+// moved to be relative to start.
+func markersInRange(markers []Marker, start, end int64) []Marker {
+	var inRange []Marker
+	for _, marker := range markers {
+		if marker.Position >= start && marker.Position < end {
+			inRange = append(inRange, Marker{marker.Position - start, marker.Label})
+		}
+	}
+	return inRange
+}
+
+// markerSplits returns a split starting at each marker, named after its
+// number and label (e.g. 01_Opening). Audio before the first marker goes to
+// 00_Start.
+func markerSplits(markers []Marker) []Split {
+	var splits []Split
+	if len(markers) > 0 && markers[0].Position > 0 {
+		splits = append(splits, Split{0, "00_Start"})
+	}
+
+	for i, marker := range markers {
+		splits = append(splits, Split{
+			Start: marker.Position,
+			Dir:   fmt.Sprintf("%02d_%s", i+1, sanitizeFileName(marker.Label)),
+		})
+	}
+
+	return splits
+}
+
+// cuePoints converts the markers within frames [start, end) to cue points
+// relative to start.
+func cuePoints(markers []Marker, start, end int64) []wav.CuePoint {
+	var cues []wav.CuePoint
+	for _, marker := range markersInRange(markers, start, end) {
+		cues = append(cues, wav.CuePoint{
+			ID:       len(cues) + 1,
+			Position: marker.Position,
+			Label:    marker.Label,
+		})
+	}
+	return cues
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadMarkersCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "markers.csv")
+	csv := "position,label\n" +
+		"00:05:30,Song 1\n" +
+		"\n" +
+		"90s, Walk in \n" +
+		"48000smp\n" +
+		"01:00:00.500,\"Song 2, reprise\"\n"
+	if err := os.WriteFile(path, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	markers, err := readMarkersCSV(path, 48000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Marker{
+		{330 * 48000, "Song 1"},
+		{90 * 48000, "Walk in"},
+		{48000, ""},
+		{3600.5 * 48000, "Song 2, reprise"},
+	}
+	if !reflect.DeepEqual(markers, want) {
+		t.Errorf("readMarkersCSV = %v, want %v", markers, want)
+	}
+
+	// only the first line can be a header
+	if err := os.WriteFile(path, []byte("00:01,A\nlater,B\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readMarkersCSV(path, 48000); err == nil {
+		t.Error("readMarkersCSV with an invalid position succeeded")
+	}
+}
+
+func TestCollectMarkers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "markers.csv")
+	if err := os.WriteFile(path, []byte("10s,Song 1\n20s,Song 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// markers at the same position are merged, keeping a label
+	session := &XLiveSession{Markers: []Marker{{10 * 48000, ""}, {5 * 48000, ""}}}
+	markers, err := collectMarkers(nil, session, path, 48000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Marker{{5 * 48000, "Marker 1"}, {10 * 48000, "Song 1"}, {20 * 48000, "Song 2"}}
+	if !reflect.DeepEqual(markers, want) {
+		t.Errorf("collectMarkers = %v, want %v", markers, want)
+	}
+
+	if got := markersInRange(markers, 6*48000, 20*48000); !reflect.DeepEqual(got, []Marker{{4 * 48000, "Song 1"}}) {
+		t.Errorf("markersInRange = %v", got)
+	}
+}
+
+func TestMarkerSplits(t *testing.T) {
+	splits := markerSplits([]Marker{{100, "Opening"}, {500, "Song: 2/3"}})
+	want := []Split{{0, "00_Start"}, {100, "01_Opening"}, {500, "02_Song_ 2_3"}}
+	if !reflect.DeepEqual(splits, want) {
+		t.Errorf("markerSplits = %v, want %v", splits, want)
+	}
+
+	// no start folder for a marker at the start
+	splits = markerSplits([]Marker{{0, "Opening"}, {500, "Close"}})
+	want = []Split{{0, "01_Opening"}, {500, "02_Close"}}
+	if !reflect.DeepEqual(splits, want) {
+		t.Errorf("markerSplits = %v, want %v", splits, want)
+	}
+}
+
+func TestSplitOnMarkers(t *testing.T) {
+	dir := t.TempDir()
+	opts := testTrackOptions(dir, 1000)
+	opts.Markers = []Marker{{100, "Opening"}, {250, "Hit"}, {600, "Close"}}
+	opts.Splits = markerSplits(opts.Markers)
+
+	tracks, err := initTracks("", "1", nil, 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := make([]float64, 1000)
+	for i := range frames {
+		frames[i] = float64(i) / (1 << 15)
+	}
+	writeTrackFrames(t, tracks[0], [][]float64{frames}, 0)
+	if err := tracks[0].Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir         string
+		first, last int
+		cues        []int64
+	}{
+		{"00_Start", 0, 100, nil},
+		{"01_Opening", 100, 250, []int64{0}},
+		{"02_Hit", 250, 600, []int64{0}},
+		{"03_Close", 600, 1000, []int64{0}},
+	}
+
+	for _, test := range tests {
+		split, cues := readTestWav(t, filepath.Join(dir, test.dir, "track_1.wav"))
+		if len(split[0]) != test.last-test.first || split[0][0] != frames[test.first] {
+			t.Errorf("%s has %d frames from %v, want %d from frame %d", test.dir, len(split[0]), split[0][0], test.last-test.first, test.first)
+		}
+
+		var positions []int64
+		for _, cue := range cues {
+			positions = append(positions, cue.Position)
+		}
+		if !reflect.DeepEqual(positions, test.cues) {
+			t.Errorf("%s cue points at %v, want %v", test.dir, positions, test.cues)
+		}
+	}
+}
+
+func TestRemoveOutputFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"track_1.wav", "01_Opening/track_1.wav", "02_Close/track_1.wav", "02_Close/notes.txt", "other/readme.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := getOutputFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("getOutputFiles = %v, want the 3 wav files", files)
+	}
+
+	if err := removeOutputFiles(dir, files); err != nil {
+		t.Fatal(err)
+	}
+
+	// folders with other files are kept
+	for name, exists := range map[string]bool{"track_1.wav": false, "01_Opening": false, "02_Close/notes.txt": true, "other/readme.txt": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != exists {
+			t.Errorf("%s exists = %v, want %v", name, err == nil, exists)
+		}
+	}
+}
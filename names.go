@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// default file name template, {session} is added for X-LIVE sessions
+const defaultNameTemplate = "{name}"
+
+var templatePlaceholder = regexp.MustCompile(`\{(\w+)(?::(\d+))?\}`)
+
+// ChannelNames maps channel keys to track names. Keys are one-based channels
+// joined by slashes (e.g. "5" or "3/4").
+type ChannelNames map[string]string
+
+// channelsKey returns the ChannelNames key of zero-based channels.
+func channelsKey(channels []int) string {
+	parts := make([]string, len(channels))
+	for i, ch := range channels {
+		parts[i] = strconv.Itoa(ch + 1)
+	}
+	return strings.Join(parts, "/")
+}
+
+// parseChannelNames parses names given inline (1=Kick,2=Snare,3/4=Keys) or
+// as a .csv (channel,name per line) or .json ({"1": "Kick"}) file.
+func parseChannelNames(str string) (ChannelNames, error) {
+	if str == "" {
+		return nil, nil
+	}
+
+	var pairs [][2]string
+	var err error
+	switch strings.ToLower(filepath.Ext(str)) {
+	case ".csv":
+		pairs, err = readChannelNamesCSV(str)
+	case ".json":
+		pairs, err = readChannelNamesJSON(str)
+	default:
+		for _, pairStr := range strings.Split(str, ",") {
+			key, name, ok := strings.Cut(pairStr, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid channel name format: %s", pairStr)
+			}
+			pairs = append(pairs, [2]string{key, name})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(ChannelNames)
+	for _, pair := range pairs {
+		var channels []int
+		for _, chStr := range strings.Split(strings.TrimSpace(pair[0]), "/") {
+			ch, err := strconv.Atoi(strings.TrimSpace(chStr))
+			if err != nil || ch < 1 {
+				return nil, fmt.Errorf("invalid channel number in name: %s", pair[0])
+			}
+			channels = append(channels, ch-1)
+		}
+
+		name := strings.TrimSpace(pair[1])
+		if name == "" {
+			return nil, fmt.Errorf("empty name for channel %s", pair[0])
+		}
+
+		key := channelsKey(channels)
+		if _, ok := names[key]; ok {
+			return nil, fmt.Errorf("duplicate name for channel %s", key)
+		}
+		names[key] = name
+	}
+
+	return names, nil
+}
+
+func readChannelNamesCSV(path string) ([][2]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var pairs [][2]string
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid names file %s: %v", path, err)
+		}
+
+		if len(record) < 2 {
+			return nil, fmt.Errorf("invalid names file %s, line %d: expected channel,name", path, line)
+		}
+
+		// skip a header row
+		if _, err := strconv.Atoi(strings.Split(record[0], "/")[0]); err != nil && line == 1 {
+			continue
+		}
+
+		pairs = append(pairs, [2]string{record[0], record[1]})
+	}
+
+	return pairs, nil
+}
+
+func readChannelNamesJSON(path string) ([][2]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names map[string]string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("invalid names file %s: %v", path, err)
+	}
+
+	var pairs [][2]string
+	for key, name := range names {
+		pairs = append(pairs, [2]string{key, name})
+	}
+
+	return pairs, nil
+}
+
+// renderNameTemplate fills in the placeholders of a file name template. A
+// width pads numbers with zeros, e.g. {index:02}.
+func renderNameTemplate(template string, values map[string]any) (string, error) {
+	var err error
+	name := templatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		match := templatePlaceholder.FindStringSubmatch(placeholder)
+		value, ok := values[match[1]]
+		if !ok {
+			err = fmt.Errorf("unknown placeholder %s in name template", placeholder)
+			return placeholder
+		}
+
+		if number, ok := value.(int); ok && match[2] != "" {
+			width, _ := strconv.Atoi(match[2])
+			return fmt.Sprintf("%0*d", width, number)
+		}
+
+		return fmt.Sprint(value)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// the extension is added when creating the files
+	if strings.EqualFold(filepath.Ext(name), ".wav") {
+		name = name[:len(name)-len(".wav")]
+	}
+
+	if name == "" {
+		return "", fmt.Errorf("name template %q gives an empty file name", template)
+	}
+
+	return name, nil
+}
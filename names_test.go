@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseChannelNames(t *testing.T) {
+	want := ChannelNames{"1": "Kick", "2": "Snare", "3/4": "Keys"}
+
+	names, err := parseChannelNames("1=Kick, 2 = Snare ,3 / 4=Keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("inline names = %v, want %v", names, want)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"names.csv":  "channel,name\n1,Kick\n2, Snare\n3/4,Keys\n",
+		"names.json": `{"1": "Kick", "2": "Snare", "3/4": "Keys"}`,
+	}
+	for file, content := range files {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		names, err := parseChannelNames(path)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("%s names = %v, want %v", file, names, want)
+		}
+	}
+
+	for _, str := range []string{"1", "0=Kick", "x=Kick", "1=", "1=Kick,1=Bass", "3/4=Keys,3 /4=Piano"} {
+		if names, err := parseChannelNames(str); err == nil {
+			t.Errorf("parseChannelNames(%q) = %v, want an error", str, names)
+		}
+	}
+}
+
+func TestRenderNameTemplate(t *testing.T) {
+	values := map[string]any{"index": 3, "name": "Kick", "channel": 12, "session": "Sunday"}
+
+	tests := []struct {
+		template string
+		name     string
+	}{
+		{"{name}", "Kick"},
+		{"{index:02}_{name}", "03_Kick"},
+		{"{session}_ch{channel:3}", "Sunday_ch012"},
+		{"{name:02}", "Kick"},
+		{"{name}.WAV", "Kick"},
+		{"track", "track"},
+	}
+
+	for _, test := range tests {
+		name, err := renderNameTemplate(test.template, values)
+		if err != nil {
+			t.Errorf("renderNameTemplate(%q): %v", test.template, err)
+			continue
+		}
+		if name != test.name {
+			t.Errorf("renderNameTemplate(%q) = %q, want %q", test.template, name, test.name)
+		}
+	}
+
+	for _, template := range []string{"{title}", ".wav", ""} {
+		if name, err := renderNameTemplate(template, values); err == nil {
+			t.Errorf("renderNameTemplate(%q) = %q, want an error", template, name)
+		}
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := map[string]string{
+		"Kick In":         "Kick In",
+		" Vox (Lead) ":    "Vox (Lead)",
+		"AC/DC: Back":     "AC_DC_ Back",
+		"Bass*?\"<>|":     "Bass______",
+		"Gitarre-Größe.1": "Gitarre-Größe.1",
+	}
+
+	for name, want := range tests {
+		if got := sanitizeFileName(name); got != want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestTrackNameClash(t *testing.T) {
+	opts := testTrackOptions(t.TempDir(), 0)
+
+	opts.Names = ChannelNames{"1": "Vocals", "2": "vocals"}
+	if _, err := initTracks("", "1,2", nil, 2, opts); err == nil {
+		t.Error("tracks named Vocals and vocals were created")
+	}
+
+	// a template without the name or channel gives every track the same name
+	opts.Names = nil
+	opts.NameTemplate = "{session}take"
+	if _, err := initTracks("", "1,2", nil, 2, opts); err == nil {
+		t.Error("tracks with the same template name were created")
+	}
+
+	opts.NameTemplate = "{index:02}_{name}"
+	tracks, err := initTracks("", "1,2", nil, 2, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracks[0].Name != "01_track_1" || tracks[1].Name != "02_track_2" {
+		t.Errorf("track names = %s, %s", tracks[0].Name, tracks[1].Name)
+	}
+}
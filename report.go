@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// level is a level in dB, -Inf for silence.
+type level float64
+
+func (l level) String() string {
+	if math.IsInf(float64(l), -1) {
+		return "-inf"
+	}
+	return strconv.FormatFloat(float64(l), 'f', 1, 64)
+}
+
+// MarshalJSON writes silence as null, JSON has no infinity.
+func (l level) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(l), 0) || math.IsNaN(float64(l)) {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.FormatFloat(float64(l), 'f', 2, 64)), nil
+}
+
+// TrackReport is the row of a track in the level report.
+type TrackReport struct {
+	Track    string  `json:"track"`
+	Channels string  `json:"channels"`
+	Peak     level   `json:"peak_dbfs"`
+	TruePeak level   `json:"true_peak_dbtp"`
+	RMS      level   `json:"rms_dbfs"`
+	Loudness level   `json:"loudness_lufs"`
+	DCOffset float64 `json:"dc_offset_percent"`
+	Clips    int64   `json:"clips"`
+}
+
+func trackReports(tracks []*Track) []TrackReport {
+	reports := make([]TrackReport, len(tracks))
+	for i, track := range tracks {
+		stats := track.Stats()
+		reports[i] = TrackReport{
+			Track:    track.Name,
+			Channels: channelsKey(track.Channels),
+			Peak:     level(stats.Peak),
+			TruePeak: level(stats.TruePeak),
+			RMS:      level(stats.RMS),
+			Loudness: level(stats.Loudness),
+			DCOffset: math.Round(stats.DCOffset*100*1000) / 1000,
+			Clips:    stats.Clips,
+		}
+	}
+	return reports
+}
+
+func printReport(reports []TrackReport) {
+	nameWidth := len("Track")
+	for _, report := range reports {
+		nameWidth = max(nameWidth, len(report.Track))
+	}
+
+	fmt.Printf("%-*s  %-8s %8s %8s %8s %8s %8s %8s\n", nameWidth, "Track", "Channels", "Peak", "TP", "RMS", "LUFS", "DC %", "Clips")
+	for _, r := range reports {
+		fmt.Printf("%-*s  %-8s %8s %8s %8s %8s %8.3f %8d\n", nameWidth, r.Track, r.Channels, r.Peak, r.TruePeak, r.RMS, r.Loudness, r.DCOffset, r.Clips)
+	}
+}
+
+// writeReport saves the level report as report.json and report.csv in dir.
+func writeReport(dir string, reports []TrackReport) error {
+	data, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "report.json"), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	file, err := os.Create(filepath.Join(dir, "report.csv"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"track", "channels", "peak_dbfs", "true_peak_dbtp", "rms_dbfs", "loudness_lufs", "dc_offset_percent", "clips"})
+	for _, r := range reports {
+		w.Write([]string{
+			r.Track,
+			r.Channels,
+			r.Peak.String(),
+			r.TruePeak.String(),
+			r.RMS.String(),
+			r.Loudness.String(),
+			strconv.FormatFloat(r.DCOffset, 'f', 3, 64),
+			strconv.FormatInt(r.Clips, 10),
+		})
+	}
+	w.Flush()
+
+	return w.Error()
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"github.com/calebmcelroy/wav-extract/wav"
+	"math"
+	"sync"
+)
+
+const (
+	// zero crossings of the sinc on each side of the kernel at the cutoff
+	resampleZeroCrossings = 64
+
+	// cutoff relative to the lower Nyquist frequency
+	resampleCutoff = 0.95
+
+	// Kaiser window beta, about 90 dB of stop band attenuation
+	resampleKaiserBeta = 9.0
+
+	// most filter phases, i.e. output rate divided by the greatest common
+	// divisor of the rates
+	resampleMaxPhases = 4096
+)
+
+var resampleFilters sync.Map // "in:out" to *resampleFilter
+
+// resampleFilter is a windowed sinc filter converting from one sample rate
+// to another, with a phase for each fractional position between input frames.
+type resampleFilter struct {
+	up, down int64 // output frame k is at input frame k*down/up
+	taps     int   // taps on each side of a position
+	phases   [][]float64
+}
+
+// getResampleFilter returns the filter converting inRate to outRate, the
+// filters are shared by all tracks.
+func getResampleFilter(inRate, outRate int) (*resampleFilter, error) {
+	key := fmt.Sprintf("%d:%d", inRate, outRate)
+	if filter, ok := resampleFilters.Load(key); ok {
+		return filter.(*resampleFilter), nil
+	}
+
+	filter, err := newResampleFilter(inRate, outRate)
+	if err != nil {
+		return nil, err
+	}
+
+	resampleFilters.Store(key, filter)
+	return filter, nil
+}
+
+func newResampleFilter(inRate, outRate int) (*resampleFilter, error) {
+	gcd := func(a, b int64) int64 {
+		for b != 0 {
+			a, b = b, a%b
+		}
+		return a
+	}(int64(inRate), int64(outRate))
+
+	f := &resampleFilter{
+		up:   int64(outRate) / gcd,
+		down: int64(inRate) / gcd,
+	}
+	if f.up > resampleMaxPhases {
+		return nil, fmt.Errorf("cannot resample %d Hz to %d Hz, the rates have too few common factors", inRate, outRate)
+	}
+
+	// downsampling lowers the cutoff below the output Nyquist frequency
+	cutoff := resampleCutoff * min(1, float64(f.up)/float64(f.down))
+	f.taps = int(math.Ceil(resampleZeroCrossings / cutoff))
+
+	f.phases = make([][]float64, f.up)
+	for p := range f.phases {
+		phase := make([]float64, 2*f.taps)
+		fraction := float64(p) / float64(f.up)
+
+		sum := 0.0
+		for i := range phase {
+			x := float64(i-f.taps+1) - fraction
+			phase[i] = cutoff * sinc(cutoff*x) * kaiser(x/float64(f.taps), resampleKaiserBeta)
+			sum += phase[i]
+		}
+
+		// unity gain at DC
+		for i := range phase {
+			phase[i] /= sum
+		}
+
+		f.phases[p] = phase
+	}
+
+	return f, nil
+}
+
+// outputFrames returns the first output frame at or after input frame n.
+func (f *resampleFilter) outputFrames(n int64) int64 {
+	return (n*f.up + f.down - 1) / f.down
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// kaiser returns the Kaiser window at x in [-1, 1].
+func kaiser(x, beta float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	return besselI0(beta*math.Sqrt(1-x*x)) / besselI0(beta)
+}
+
+// besselI0 is the zeroth order modified Bessel function of the first kind.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1.0; term > sum*1e-12; k++ {
+		term *= (x / (2 * k)) * (x / (2 * k))
+		sum += term
+	}
+	return sum
+}
+
+// resampler resamples a run of consecutive frames of a track. Files are
+// extracted in parallel, so each file is given the frames around it as
+// context and produces the output frames between its first frame and the
+// first frame of the next file. The output is the same as resampling the
+// whole recording at once, without clicks at the seams.
+type resampler struct {
+	*resampleFilter
+
+	in      [][]float64 // buffered input of each channel
+	inStart int64       // input frame of in[ch][0]
+	next    int64       // next output frame
+	end     int64       // output frame after the last one to produce
+}
+
+// newResampler returns a resampler producing the output frames of input
+// frames [start, end). The first frames pushed must be the filter taps of
+// context before start.
+func (f *resampleFilter) newResampler(numChans int, start, end int64) *resampler {
+	return &resampler{
+		resampleFilter: f,
+		in:             make([][]float64, numChans),
+		inStart:        start - int64(f.taps),
+		next:           f.outputFrames(start),
+		end:            f.outputFrames(end),
+	}
+}
+
+// push adds input frames, one slice per channel.
+func (r *resampler) push(frames [][]float64) {
+	for ch := range r.in {
+		r.in[ch] = append(r.in[ch], frames[ch]...)
+	}
+}
+
+// pull resamples the buffered input into dst, one slice per channel, and
+// returns the number of output frames.
+func (r *resampler) pull(dst [][]float64) int {
+	n := 0
+	inEnd := r.inStart + int64(len(r.in[0]))
+	for ; n < len(dst[0]) && r.next < r.end; n++ {
+		pos := r.next * r.down
+		frame := pos / r.up
+		if frame+int64(r.taps) >= inEnd {
+			break
+		}
+
+		phase := r.phases[pos%r.up]
+		first := frame - int64(r.taps) + 1 - r.inStart
+		for ch, in := range r.in {
+			y := 0.0
+			for i, coef := range phase {
+				y += coef * in[first+int64(i)]
+			}
+			dst[ch][n] = y
+		}
+		r.next++
+	}
+
+	// drop input that is no longer needed
+	if keep := r.next*r.down/r.up - int64(r.taps) + 1 - r.inStart; keep > 0 {
+		keep = min(keep, int64(len(r.in[0])))
+		for ch := range r.in {
+			r.in[ch] = append(r.in[ch][:0], r.in[ch][keep:]...)
+		}
+		r.inStart += keep
+	}
+
+	return n
+}
+
+// resampleEdges reads the frames of the timeline within the filter taps
+// before timeline frame from and after frame to, for resampling the frames
+// between them. Frames outside the extracted range [start, end) are silent.
+func resampleEdges(wavFiles []*WavFile, tracks []*Track, start, end, from, to int64) ([2][][]float64, error) {
+	var edges [2][][]float64
+	taps := resampleTaps(tracks)
+	if taps == 0 {
+		return edges, nil
+	}
+
+	for i, pos := range []int64{from - int64(taps), to} {
+		var err error
+		edges[i], err = readRangeFrames(wavFiles, start, end, pos, taps)
+		if err != nil {
+			return edges, err
+		}
+	}
+
+	return edges, nil
+}
+
+// resampleTaps returns the most filter taps of the resampled tracks, or 0 if
+// no track is resampled.
+func resampleTaps(tracks []*Track) int {
+	taps := 0
+	for _, track := range tracks {
+		if track.resample != nil {
+			taps = max(taps, track.resample.taps)
+		}
+	}
+	return taps
+}
+
+// channelFrames returns the frames of the channels, one slice per channel.
+func channelFrames(frames [][]float64, channels []int) [][]float64 {
+	selected := make([][]float64, len(channels))
+	for i, ch := range channels {
+		selected[i] = frames[ch]
+	}
+	return selected
+}
+
+// decodeChannels decodes the samples of the channels from interleaved frames.
+func decodeChannels(p []byte, blockAlign, bytesPerSample int, channels []int, decode wav.DecodeFunc) [][]float64 {
+	frames := make([][]float64, len(channels))
+	for i, ch := range channels {
+		frames[i] = make([]float64, len(p)/blockAlign)
+		for frame := range frames[i] {
+			offset := frame*blockAlign + ch*bytesPerSample
+			frames[i][frame] = decode(p[offset : offset+bytesPerSample])
+		}
+	}
+	return frames
+}
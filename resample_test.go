@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A sine split across input files, including a file shorter than the filter
+// taps, is resampled without clicks where the files meet.
+func TestResampleAcrossFiles(t *testing.T) {
+	const inRate, outRate = 48000, 44100
+
+	frames := make([]float64, 60000)
+	for i := range frames {
+		frames[i] = 0.5 * math.Sin(2*math.Pi*1000*float64(i)/inRate)
+	}
+	lengths := []int{10000, 7, 20000, 13, 29980}
+
+	resample := func(start, end int64, lengths ...int) []float64 {
+		opts := testFloatTrackOptions(t.TempDir(), end-start)
+		opts.SampleRate = outRate
+		tracks, err := initTracks("", "1", nil, 1, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wavFiles := openTestWavs(t, inRate, [][]float64{frames}, lengths...)
+		extract(context.Background(), wavFiles, tracks, start, end, time.Millisecond, func(Progress) {})
+		if err := tracks[0].Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		out, _ := readTestWav(t, filepath.Join(opts.OutputDir, "track_1.wav"))
+		if want := tracks[0].resample.outputFrames(end - start); int64(len(out[0])) != want {
+			t.Errorf("%d frames resampled from [%d, %d), want %d", len(out[0]), start, end, want)
+		}
+		return out[0]
+	}
+
+	whole := resample(0, int64(len(frames)), len(frames))
+	files := resample(0, int64(len(frames)), lengths...)
+	if len(whole) != len(files) {
+		t.Fatalf("%d frames from one file, %d from %d files", len(whole), len(files), len(lengths))
+	}
+
+	// the same output as one file, and the sine around every seam
+	seam := 0
+	for _, length := range lengths[:len(lengths)-1] {
+		seam += length
+		center := seam * outRate / inRate
+		maxError := 0.0
+		for i := center - 200; i < center+200; i++ {
+			want := 0.5 * math.Sin(2*math.Pi*1000*float64(i)/outRate)
+			maxError = max(maxError, math.Abs(files[i]-want), math.Abs(files[i]-whole[i]))
+		}
+		if maxError > 1e-4 {
+			t.Errorf("error around the seam at input frame %d is %g", seam, maxError)
+		}
+	}
+
+	// a range starting and ending within files, the output frames follow the
+	// position of each file in the range
+	start, end := int64(15000), int64(40000)
+	part := resample(start, end, lengths...)
+	offset := float64(start) / inRate
+	maxError := 0.0
+	for i := 500; i < len(part)-500; i++ {
+		want := 0.5 * math.Sin(2*math.Pi*1000*(offset+float64(i)/outRate))
+		maxError = max(maxError, math.Abs(part[i]-want))
+	}
+	if maxError > 1e-4 {
+		t.Errorf("error of the range [%d, %d) is %g", start, end, maxError)
+	}
+}
+
+func TestResamplerFrames(t *testing.T) {
+	filter, err := getResampleFilter(48000, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.up != 147 || filter.down != 160 {
+		t.Errorf("48000 to 44100 Hz is %d/%d, want 147/160", filter.up, filter.down)
+	}
+
+	// the output frames of consecutive runs of input frames meet without gaps
+	// or overlaps
+	runs := []int64{0, 1000, 1001, 1160, 23457, 48000}
+	next := int64(0)
+	for i := 1; i < len(runs); i++ {
+		r := filter.newResampler(1, runs[i-1], runs[i])
+		if r.next != next {
+			t.Errorf("frames from %d start at output frame %d, want %d", runs[i-1], r.next, next)
+		}
+		next = r.end
+	}
+	if next != 44100 {
+		t.Errorf("48000 frames resampled to %d frames, want 44100", next)
+	}
+
+	// output frames are pulled once enough input follows them
+	r := filter.newResampler(1, 0, 1000)
+	r.push([][]float64{make([]float64, filter.taps+1000)})
+	dst := [][]float64{make([]float64, 2000)}
+	n := r.pull(dst)
+	if want := filter.outputFrames(1000 - int64(filter.taps)); int64(n) != want {
+		t.Errorf("pulled %d frames before the edge, want %d", n, want)
+	}
+	r.push([][]float64{make([]float64, filter.taps)})
+	n += r.pull(dst)
+	if int64(n) != filter.outputFrames(1000) || r.next != r.end {
+		t.Errorf("pulled %d frames of 1000 input frames, want %d", n, filter.outputFrames(1000))
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const x32Channels = 32
+
+var (
+	sceneChannelConfig = regexp.MustCompile(`^/ch/(\d\d)/config$`)
+	sceneRoutingBlock  = regexp.MustCompile(`^([A-Za-z]+)(\d+)-(\d+)$`)
+)
+
+// X32Scene holds the parts of an X32/M32 scene (.scn) file needed to name
+// the channels recorded through the card.
+type X32Scene struct {
+	ChannelNames   [x32Channels]string
+	ChannelSources [x32Channels]int      // input number of each channel, 0 if off
+	ChannelLinks   [x32Channels / 2]bool // channel 1/2, 3/4... stereo links
+
+	// /config/routing blocks of 8, e.g. AN1-8 or IN9-16
+	InputRouting []string
+	CardRouting  []string
+}
+
+func readX32Scene(path string) (*X32Scene, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scene := &X32Scene{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := splitSceneLine(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case sceneChannelConfig.MatchString(fields[0]):
+			// /ch/01/config "Kick" icon color source
+			ch, _ := strconv.Atoi(sceneChannelConfig.FindStringSubmatch(fields[0])[1])
+			if ch < 1 || ch > x32Channels || len(fields) < 5 {
+				return nil, fmt.Errorf("invalid scene file %s, line %d: %s", path, line, scanner.Text())
+			}
+
+			scene.ChannelNames[ch-1] = fields[1]
+			scene.ChannelSources[ch-1], err = strconv.Atoi(fields[4])
+			if err != nil {
+				return nil, fmt.Errorf("invalid scene file %s, line %d: invalid source %s", path, line, fields[4])
+			}
+		case fields[0] == "/config/chlink":
+			for i, link := range fields[1:min(len(fields), len(scene.ChannelLinks)+1)] {
+				scene.ChannelLinks[i] = link == "ON"
+			}
+		case fields[0] == "/config/routing/IN":
+			scene.InputRouting = fields[1:]
+		case fields[0] == "/config/routing/CARD":
+			scene.CardRouting = fields[1:]
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return scene, nil
+}
+
+// splitSceneLine splits a scene line on spaces, keeping quoted names together.
+func splitSceneLine(line string) []string {
+	var fields []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if line[0] == '"' {
+			value, rest, _ := strings.Cut(line[1:], `"`)
+			fields = append(fields, value)
+			line = rest
+			continue
+		}
+
+		value, rest, _ := strings.Cut(line, " ")
+		fields = append(fields, value)
+		line = rest
+	}
+	return fields
+}
+
+// cardChannels returns the zero-based channel recorded on each card output,
+// or -1 when a card output doesn't carry the input of a channel.
+func (s *X32Scene) cardChannels(numChans int) []int {
+	// physical inputs (e.g. AN 9) feeding each input number
+	inputs := make(map[string]int)
+	for block, routing := range s.InputRouting {
+		prefix, first, ok := parseRoutingBlock(routing)
+		for i := 0; ok && i < 8; i++ {
+			inputs[prefix+strconv.Itoa(first+i)] = block*8 + i + 1
+		}
+	}
+
+	channels := make([]int, numChans)
+	for slot := range channels {
+		channels[slot] = -1
+
+		// without routing the card records inputs 1-32
+		input := slot + 1
+		if slot/8 < len(s.CardRouting) {
+			prefix, first, ok := parseRoutingBlock(s.CardRouting[slot/8])
+			physical := prefix + strconv.Itoa(first+slot%8)
+			switch {
+			case !ok:
+				continue
+			case prefix == "IN":
+				input = first + slot%8
+			case inputs[physical] != 0:
+				input = inputs[physical]
+			case len(s.InputRouting) == 0 && prefix == "AN":
+				input = first + slot%8
+			default:
+				continue
+			}
+		}
+
+		for ch, source := range s.ChannelSources {
+			if source == input {
+				channels[slot] = ch
+				break
+			}
+		}
+	}
+
+	return channels
+}
+
+// parseRoutingBlock parses a routing block such as AN9-16.
+func parseRoutingBlock(block string) (prefix string, first int, ok bool) {
+	match := sceneRoutingBlock.FindStringSubmatch(block)
+	if match == nil {
+		return "", 0, false
+	}
+
+	first, _ = strconv.Atoi(match[2])
+	return strings.ToUpper(match[1]), first, true
+}
+
+// tracks returns the track names of the card outputs and the stereo pairs
+// (e.g. 1/2,5/6) of card outputs carrying linked channels.
+func (s *X32Scene) tracks(numChans int) (ChannelNames, string) {
+	names := make(ChannelNames)
+	var pairs []string
+
+	channels := s.cardChannels(numChans)
+	for slot, ch := range channels {
+		if ch >= 0 && s.ChannelNames[ch] != "" {
+			names[channelsKey([]int{slot})] = s.ChannelNames[ch]
+		}
+
+		// linked channels recorded side by side on an odd/even pair of outputs
+		if slot%2 == 1 || slot+1 >= len(channels) || ch < 0 || ch%2 == 1 {
+			continue
+		}
+		if !s.ChannelLinks[ch/2] || channels[slot+1] != ch+1 {
+			continue
+		}
+
+		key := channelsKey([]int{slot, slot + 1})
+		pairs = append(pairs, key)
+		if name := stereoName(s.ChannelNames[ch], s.ChannelNames[ch+1]); name != "" {
+			names[key] = name
+		}
+	}
+
+	return names, strings.Join(pairs, ",")
+}
+
+// stereoName returns a name for a linked pair, e.g. "Keys" for "Keys L" and "Keys R".
+func stereoName(left, right string) string {
+	if left == right || right == "" {
+		return left
+	}
+	if left == "" {
+		return right
+	}
+
+	// common words of the names, cut at a separator
+	prefix := 0
+	for prefix < len(left) && prefix < len(right) && left[prefix] == right[prefix] {
+		prefix++
+	}
+	if sep := strings.LastIndexAny(left[:prefix], " -_"); sep > 0 {
+		return strings.TrimRight(left[:sep], " -_")
+	}
+
+	return left + " & " + right
+}
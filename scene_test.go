@@ -0,0 +1,112 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testdata/x32/Sunday.scn is a scene trimmed to the config, routing and a few
+// lines of every channel. Channels 3 and 4 swap inputs, channel 8 is off and
+// 5/6 and 31/32 are linked.
+func TestReadX32Scene(t *testing.T) {
+	scene, err := readX32Scene(filepath.Join("testdata", "x32", "Sunday.scn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if scene.ChannelNames[0] != "Kick" || scene.ChannelNames[2] != "Hi Hat" || scene.ChannelNames[7] != "" || scene.ChannelNames[31] != "Track R" {
+		t.Errorf("channel names = %q", scene.ChannelNames)
+	}
+	if scene.ChannelSources[2] != 4 || scene.ChannelSources[3] != 3 || scene.ChannelSources[7] != 0 || scene.ChannelSources[31] != 32 {
+		t.Errorf("channel sources = %v", scene.ChannelSources)
+	}
+
+	var links []int
+	for i, linked := range scene.ChannelLinks {
+		if linked {
+			links = append(links, i)
+		}
+	}
+	if !reflect.DeepEqual(links, []int{2, 15}) {
+		t.Errorf("linked pairs = %v, want [2 15]", links)
+	}
+
+	if want := []string{"IN1-8", "IN9-16", "IN17-24", "IN25-32"}; !reflect.DeepEqual(scene.CardRouting, want) {
+		t.Errorf("card routing = %v, want %v", scene.CardRouting, want)
+	}
+
+	names, pairs := scene.tracks(32)
+	if pairs != "5/6,31/32" {
+		t.Errorf("stereo pairs = %q, want 5/6,31/32", pairs)
+	}
+
+	want := map[string]string{"1": "Kick", "3": "Tom", "4": "Hi Hat", "5/6": "Keys", "8": "", "15": "Bass DI", "31/32": "Track"}
+	for key, name := range want {
+		if names[key] != name {
+			t.Errorf("name of %s = %q, want %q", key, names[key], name)
+		}
+	}
+
+	// 8 card outputs only record the first block
+	if _, pairs := scene.tracks(8); pairs != "5/6" {
+		t.Errorf("stereo pairs of 8 channels = %q, want 5/6", pairs)
+	}
+}
+
+func TestCardChannels(t *testing.T) {
+	var sources [x32Channels]int
+	for ch := range sources {
+		sources[ch] = ch + 1
+	}
+
+	tests := []struct {
+		name          string
+		inputRouting  []string
+		cardRouting   []string
+		first, second int // channels on card outputs 1 and 9
+	}{
+		{"no routing", nil, nil, 0, 8},
+		{"input blocks", nil, []string{"IN9-16", "IN1-8"}, 8, 0},
+		{"analog inputs", []string{"AN9-16", "AN1-8"}, []string{"AN1-8", "AN9-16"}, 8, 0},
+		{"analog without input routing", nil, []string{"AN17-24", "AN1-8"}, 16, 0},
+		{"unrouted source", []string{"AN1-8"}, []string{"A1-8", "OFF"}, -1, -1},
+		{"outputs past the routing", nil, []string{"IN25-32"}, 24, 8},
+	}
+
+	for _, test := range tests {
+		scene := &X32Scene{ChannelSources: sources, InputRouting: test.inputRouting, CardRouting: test.cardRouting}
+		channels := scene.cardChannels(16)
+		if channels[0] != test.first || channels[8] != test.second {
+			t.Errorf("%s: card outputs 1 and 9 record channels %d and %d, want %d and %d", test.name, channels[0], channels[8], test.first, test.second)
+		}
+	}
+
+	// a channel without a source isn't recorded
+	sources[0] = 0
+	scene := &X32Scene{ChannelSources: sources}
+	if channels := scene.cardChannels(2); !reflect.DeepEqual(channels, []int{-1, 1}) {
+		t.Errorf("card channels = %v, want [-1 1]", channels)
+	}
+}
+
+func TestStereoName(t *testing.T) {
+	tests := []struct {
+		left, right, name string
+	}{
+		{"Keys L", "Keys R", "Keys"},
+		{"OH-L", "OH-R", "OH"},
+		{"Track 1_L", "Track 1_R", "Track 1"},
+		{"Piano", "Piano", "Piano"},
+		{"Piano", "", "Piano"},
+		{"", "Piano", "Piano"},
+		{"Guitar", "Bass", "Guitar & Bass"},
+		{"Vox1", "Vox2", "Vox1 & Vox2"},
+	}
+
+	for _, test := range tests {
+		if name := stereoName(test.left, test.right); name != test.name {
+			t.Errorf("stereoName(%q, %q) = %q, want %q", test.left, test.right, name, test.name)
+		}
+	}
+}
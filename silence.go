@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tracks peaking below this level are silent, unless another level is given
+const defaultSilenceLevel = -80.0 // dBFS
+
+// silenceFlag is the --skip-silent flag, used alone or with a level
+// (--skip-silent=-70dBFS).
+type silenceFlag struct {
+	enabled bool
+	level   float64
+}
+
+func (f *silenceFlag) String() string {
+	if f == nil || !f.enabled {
+		return "false"
+	}
+	return fmt.Sprintf("%gdBFS", f.level)
+}
+
+func (f *silenceFlag) Set(str string) error {
+	switch strings.ToLower(str) {
+	case "true":
+		f.enabled, f.level = true, defaultSilenceLevel
+		return nil
+	case "false":
+		f.enabled = false
+		return nil
+	}
+
+	level, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(str), "dbfs"), 64)
+	if err != nil || level > 0 {
+		return fmt.Errorf("invalid level %s, expected dBFS (e.g. -80dBFS)", str)
+	}
+
+	f.enabled, f.level = true, level
+	return nil
+}
+
+// IsBoolFlag lets --skip-silent be used without a level.
+func (f *silenceFlag) IsBoolFlag() bool {
+	return true
+}
+
+// removeSilentTracks deletes the tracks whose channels all peak below level
+// and returns the tracks that are kept and removed.
+func removeSilentTracks(tracks []*Track, level float64) (kept, removed []*Track, err error) {
+	for _, track := range tracks {
+		if track.Peak() >= level {
+			kept = append(kept, track)
+			continue
+		}
+
+		if err := track.Remove(); err != nil {
+			return nil, nil, fmt.Errorf("failed to remove %s: %v", track.Name, err)
+		}
+		removed = append(removed, track)
+	}
+
+	return kept, removed, nil
+}
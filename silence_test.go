@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSilenceFlag(t *testing.T) {
+	tests := []struct {
+		args    []string
+		enabled bool
+		level   float64
+	}{
+		{nil, false, 0},
+		{[]string{"--skip-silent"}, true, defaultSilenceLevel},
+		{[]string{"--skip-silent=-70dBFS"}, true, -70},
+		{[]string{"--skip-silent=-60.5dbfs"}, true, -60.5},
+		{[]string{"--skip-silent=-90"}, true, -90},
+		{[]string{"--skip-silent=false"}, false, 0},
+	}
+
+	for _, test := range tests {
+		var f silenceFlag
+		flags := flag.NewFlagSet("wav-extract", flag.ContinueOnError)
+		flags.Var(&f, "skip-silent", "")
+		if err := flags.Parse(test.args); err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+		if f.enabled != test.enabled || f.level != test.level {
+			t.Errorf("%v = %v, %g, want %v, %g", test.args, f.enabled, f.level, test.enabled, test.level)
+		}
+	}
+
+	for _, arg := range []string{"--skip-silent=6dBFS", "--skip-silent=-70dB", "--skip-silent=quiet"} {
+		var f silenceFlag
+		flags := flag.NewFlagSet("wav-extract", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		flags.Var(&f, "skip-silent", "")
+		if err := flags.Parse([]string{arg}); err == nil {
+			t.Errorf("%s was accepted", arg)
+		}
+	}
+}
+
+func TestRemoveSilentTracks(t *testing.T) {
+	dir := t.TempDir()
+	tracks, err := initTracks("1/2,3/4", "", nil, 6, testTrackOptions(dir, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// peaks of channels 1-6 in dBFS
+	peaks := []float64{math.Inf(-1), -95, -85, -20, math.Inf(-1), -79.9}
+	for _, track := range tracks {
+		for ch, input := range track.Channels {
+			track.stats[ch].peak = math.Pow(10, peaks[input]/20)
+		}
+	}
+
+	// a stereo track is kept when either channel is above the level
+	kept, removed, err := removeSilentTracks(tracks, defaultSilenceLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(tracks []*Track) (names []string) {
+		for _, track := range tracks {
+			names = append(names, track.Name)
+		}
+		return names
+	}
+	if got := names(kept); len(got) != 2 || got[0] != "track_3L_4R" || got[1] != "track_6" {
+		t.Errorf("kept %v, want track_3L_4R and track_6", got)
+	}
+	if got := names(removed); len(got) != 2 || got[0] != "track_1L_2R" || got[1] != "track_5" {
+		t.Errorf("removed %v, want track_1L_2R and track_5", got)
+	}
+
+	for _, track := range removed {
+		if _, err := os.Stat(filepath.Join(dir, track.Name+".wav")); !os.IsNotExist(err) {
+			t.Errorf("%s.wav was not deleted", track.Name)
+		}
+	}
+	for _, track := range kept {
+		if _, err := os.Stat(filepath.Join(dir, track.Name+".wav")); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// a higher level
+	kept, _, err = removeSilentTracks(kept, -70)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(kept); len(got) != 1 || got[0] != "track_3L_4R" {
+		t.Errorf("kept %v at -70 dBFS, want track_3L_4R", got)
+	}
+}
+
+func TestRemoveSplitTracks(t *testing.T) {
+	dir := t.TempDir()
+	opts := testTrackOptions(dir, 200)
+	opts.Markers = []Marker{{100, "Opening"}}
+	opts.Splits = markerSplits(opts.Markers)
+
+	tracks, err := initTracks("", "1,2", nil, 2, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, track := range tracks {
+		writeTrackFrames(t, track, [][]float64{make([]float64, 200)}, 0)
+	}
+
+	// the folders are removed with the last track in them
+	for i, track := range tracks {
+		if err := track.Remove(); err != nil {
+			t.Fatal(err)
+		}
+		for _, split := range opts.Splits {
+			if _, err := os.Stat(filepath.Join(dir, split.Dir)); (err == nil) != (i == 0) {
+				t.Errorf("%s exists = %v after removing %d tracks", split.Dir, err == nil, i+1)
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+const (
+	// windows spread over the recording that are analysed
+	stereoWindows       = 8
+	stereoWindowSeconds = 2
+
+	// pairs are stereo when correlated and at similar levels
+	stereoMinCorrelation = 0.5
+	stereoMaxLevelDiff   = 6.0   // dB
+	stereoSilenceLevel   = -70.0 // dBFS
+
+	// nearly identical channels at the same level are one mono source
+	dualMonoCorrelation = 0.98
+	dualMonoLevelDiff   = 1.0 // dB
+)
+
+// StereoPair is the analysis of two adjacent channels.
+type StereoPair struct {
+	Channels    []int // zero-based
+	Correlation float64
+	Levels      [2]float64 // RMS in dBFS
+	Stereo      bool
+	DualMono    bool  // the same signal on both channels
+	Overlap     []int // a better pair sharing a channel
+}
+
+func (p StereoPair) String() string {
+	decision := "mono"
+	switch {
+	case p.Stereo:
+		decision = "stereo"
+	case p.DualMono:
+		decision = "dual mono"
+	case p.Overlap != nil:
+		decision = "overlaps " + channelsKey(p.Overlap)
+	}
+
+	return fmt.Sprintf("%-7s correlation %5.2f  levels %6.1f / %6.1f dBFS  %s", channelsKey(p.Channels), p.Correlation, p.Levels[0], p.Levels[1], decision)
+}
+
+// detectStereoPairs analyses windows of the timeline range [start, end) to
+// find adjacent channel pairs that carry a stereo source. Every adjacent pair
+// is scored, so stereo sources starting on an even channel (2/3) are found
+// too; pairs sharing a channel are resolved by correlation.
+func detectStereoPairs(wavFiles []*WavFile, start, end int64) ([]StereoPair, error) {
+	numChans := wavFiles[0].NumChans
+	windowFrames := min(int64(stereoWindowSeconds*wavFiles[0].SampleRate), end-start)
+	windows := int64(stereoWindows)
+	if (end-start)/windowFrames < windows {
+		windows = max((end-start)/windowFrames, 1)
+	}
+
+	// sums of each channel, its square and its product with the next channel
+	sums := make([]float64, numChans)
+	squares := make([]float64, numChans)
+	products := make([]float64, numChans)
+	count := 0
+
+	frames := make([][]float64, numChans)
+	for ch := range frames {
+		frames[ch] = make([]float64, windowFrames)
+	}
+
+	for w := int64(0); w < windows; w++ {
+		pos := start + (end-start-windowFrames)*w/max(windows-1, 1)
+		n, err := readTimelineFrames(wavFiles, pos, frames)
+		if err != nil {
+			return nil, err
+		}
+
+		for ch := range frames {
+			for _, v := range frames[ch][:n] {
+				sums[ch] += v
+				squares[ch] += v * v
+			}
+			if ch+1 < numChans {
+				for i, v := range frames[ch][:n] {
+					products[ch] += v * frames[ch+1][i]
+				}
+			}
+		}
+		count += n
+	}
+
+	var pairs []StereoPair
+	for ch := 0; ch+1 < numChans; ch++ {
+		pair := StereoPair{Channels: []int{ch, ch + 1}}
+
+		for i := range 2 {
+			pair.Levels[i] = stereoSilenceLevel
+			if meanSquare := squares[ch+i] / float64(count); meanSquare > 0 {
+				pair.Levels[i] = max(10*math.Log10(meanSquare), stereoSilenceLevel)
+			}
+		}
+
+		n := float64(count)
+		covariance := products[ch]/n - sums[ch]/n*sums[ch+1]/n
+		variance := (squares[ch]/n - math.Pow(sums[ch]/n, 2)) * (squares[ch+1]/n - math.Pow(sums[ch+1]/n, 2))
+		if variance > 0 {
+			pair.Correlation = covariance / math.Sqrt(variance)
+		}
+
+		silent := pair.Levels[0] <= stereoSilenceLevel || pair.Levels[1] <= stereoSilenceLevel
+		levelDiff := math.Abs(pair.Levels[0] - pair.Levels[1])
+		pair.DualMono = !silent && pair.Correlation > dualMonoCorrelation && levelDiff <= dualMonoLevelDiff
+		pair.Stereo = !silent && !pair.DualMono &&
+			pair.Correlation >= stereoMinCorrelation &&
+			levelDiff <= stereoMaxLevelDiff
+
+		pairs = append(pairs, pair)
+	}
+
+	// keep the best correlated of pairs sharing a channel, lower channels
+	// first on a tie
+	order := make([]int, len(pairs))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(pairs[b].Correlation, pairs[a].Correlation)
+	})
+
+	used := make([]int, numChans) // one-based index of the pair using a channel
+	for _, i := range order {
+		pair := &pairs[i]
+		if !pair.Stereo {
+			continue
+		}
+
+		if other := max(used[pair.Channels[0]], used[pair.Channels[1]]); other > 0 {
+			pair.Stereo = false
+			pair.Overlap = pairs[other-1].Channels
+			continue
+		}
+		used[pair.Channels[0]] = i + 1
+		used[pair.Channels[1]] = i + 1
+	}
+
+	return pairs, nil
+}
+
+// confirmStereoPairs prints the analysis and asks whether to use the detected
+// pairs. It returns the pairs to use as a --stereo string.
+func confirmStereoPairs(pairs []StereoPair, in io.Reader, skipPrompt bool) string {
+	var stereo []string
+	fmt.Println("Auto stereo detection:")
+	for _, pair := range pairs {
+		// offset pairs (2/3...) are only listed when they matter
+		if pair.Channels[0]%2 == 0 || pair.Stereo || pair.DualMono || pair.Overlap != nil {
+			fmt.Println("  " + pair.String())
+		}
+		if pair.Stereo {
+			stereo = append(stereo, channelsKey(pair.Channels))
+		}
+	}
+
+	if len(stereo) == 0 {
+		fmt.Println("No stereo pairs detected, extracting all channels as mono.")
+		return ""
+	}
+
+	stereoStr := strings.Join(stereo, ",")
+	if !skipPrompt {
+		fmt.Printf("Use stereo pairs %s? [Y/n] ", stereoStr)
+
+		// no answer (e.g. input is not a terminal) accepts the pairs
+		answer, _ := bufio.NewReader(in).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer == "n" || answer == "no" {
+			fmt.Println("Extracting all channels as mono.")
+			return ""
+		}
+	}
+
+	fmt.Println("Stereo pairs:", stereoStr)
+	return stereoStr
+}
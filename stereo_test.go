@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/calebmcelroy/wav-extract/wav"
+	"math/rand/v2"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectStereoPairs(t *testing.T) {
+	const sampleRate = 8000
+	random := rand.New(rand.NewPCG(1, 2))
+	noise := func() float64 { return random.NormFloat64() * 0.05 }
+
+	// 1 unrelated, 2/3 stereo, 4 less correlated with 3 than 2 is, 5/6 dual
+	// mono, 7/8 stereo
+	frames := make([][]float64, 8)
+	for ch := range frames {
+		frames[ch] = make([]float64, 4*sampleRate)
+	}
+	for i := range frames[0] {
+		source, other, third := noise(), noise(), noise()
+		frames[0][i] = noise()
+		frames[1][i] = source + noise()/2
+		frames[2][i] = source + noise()/2
+		frames[3][i] = source + noise()
+		frames[4][i] = other
+		frames[5][i] = other
+		frames[6][i] = third/2 + noise()/3
+		frames[7][i] = third/2 + noise()/3
+	}
+
+	path := filepath.Join(t.TempDir(), "00000001.WAV")
+	writeTestWav(t, path, wav.FormatPCM, 16, sampleRate, frames)
+	wavFiles, err := initReaders([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wavFiles[0].Close()
+
+	pairs, err := detectStereoPairs(wavFiles, 0, wavFiles[0].Frames())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 7 {
+		t.Fatalf("%d pairs analysed, want every adjacent pair", len(pairs))
+	}
+
+	want := []struct {
+		stereo, dualMono bool
+		overlap          string
+	}{
+		{false, false, ""},    // 1/2
+		{true, false, ""},     // 2/3
+		{false, false, "2/3"}, // 3/4
+		{false, false, ""},    // 4/5
+		{false, true, ""},     // 5/6
+		{false, false, ""},    // 6/7
+		{true, false, ""},     // 7/8
+	}
+	for i, pair := range pairs {
+		overlap := ""
+		if pair.Overlap != nil {
+			overlap = channelsKey(pair.Overlap)
+		}
+		if pair.Stereo != want[i].stereo || pair.DualMono != want[i].dualMono || overlap != want[i].overlap {
+			t.Errorf("%s", pair)
+		}
+	}
+
+	if stereoStr := confirmStereoPairs(pairs, nil, true); stereoStr != "2/3,7/8" {
+		t.Errorf("confirmStereoPairs = %q, want 2/3,7/8", stereoStr)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// timelinePositions returns the first frame of each wav file on the timeline
+// formed by playing the files one after another.
+func timelinePositions(wavFiles []*WavFile) []int64 {
+	positions := make([]int64, len(wavFiles))
+	pos := int64(0)
+	for i, wavFile := range wavFiles {
+		positions[i] = pos
+		pos += wavFile.Frames()
+	}
+	return positions
+}
+
+// timelineFrames returns the number of frames of the timeline.
+func timelineFrames(wavFiles []*WavFile) int64 {
+	frames := int64(0)
+	for _, wavFile := range wavFiles {
+		frames += wavFile.Frames()
+	}
+	return frames
+}
+
+// fileRange returns the frames of the wav file, starting at timeline frame
+// filePos, that fall within timeline frames [start, end).
+func fileRange(wavFile *WavFile, filePos, start, end int64) (readStart, readEnd int64) {
+	readStart = max(start-filePos, 0)
+	readEnd = min(end-filePos, wavFile.Frames())
+	return readStart, max(readStart, readEnd)
+}
+
+// parseRange parses the --start and --end positions into timeline frames
+// [start, end). Empty values default to the start and end of the timeline.
+func parseRange(startStr, endStr string, sampleRate int, totalFrames int64) (start, end int64, err error) {
+	end = totalFrames
+
+	if startStr != "" {
+		start, err = parsePosition(startStr, sampleRate)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid --start: %v", err)
+		}
+	}
+
+	if endStr != "" {
+		end, err = parsePosition(endStr, sampleRate)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid --end: %v", err)
+		}
+	}
+
+	if start < 0 || end < 0 {
+		return 0, 0, fmt.Errorf("--start and --end cannot be negative")
+	}
+
+	if start >= totalFrames {
+		return 0, 0, fmt.Errorf("--start %s is past the end of the recording (%s)", startStr, formatPosition(totalFrames, sampleRate))
+	}
+
+	if end <= start {
+		return 0, 0, fmt.Errorf("--end must be after --start")
+	}
+
+	return start, min(end, totalFrames), nil
+}
+
+// parsePosition parses a position given as a timestamp (hh:mm:ss.mmm or
+// mm:ss.mmm), seconds (90 or 90.5s), milliseconds (1500ms) or samples
+// (4320000smp) and returns it in frames.
+func parsePosition(str string, sampleRate int) (int64, error) {
+	str = strings.TrimSpace(str)
+
+	if samples, ok := strings.CutSuffix(str, "smp"); ok {
+		frames, err := strconv.ParseInt(samples, 10, 64)
+		if err != nil || !isDecimal(samples, false) {
+			return 0, fmt.Errorf("invalid sample count: %s", str)
+		}
+		return frames, nil
+	}
+
+	if ms, ok := strings.CutSuffix(str, "ms"); ok {
+		milliseconds, err := strconv.ParseFloat(ms, 64)
+		if err != nil || !isDecimal(ms, true) {
+			return 0, fmt.Errorf("invalid milliseconds: %s", str)
+		}
+		return positionFrames(str, milliseconds/1000, sampleRate)
+	}
+
+	parts := strings.Split(strings.TrimSuffix(str, "s"), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp: %s", str)
+	}
+
+	// hours & minutes are whole numbers, seconds may have a fraction
+	seconds := 0.0
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || !isDecimal(part, i == len(parts)-1) || (i > 0 && value >= 60) {
+			return 0, fmt.Errorf("invalid timestamp: %s", str)
+		}
+
+		seconds = seconds*60 + value
+	}
+
+	return positionFrames(str, seconds, sampleRate)
+}
+
+// isDecimal returns whether str is digits, with a fraction if allowed. Signs,
+// exponents, inf and NaN are rejected.
+func isDecimal(str string, fraction bool) bool {
+	whole, frac, hasFrac := strings.Cut(str, ".")
+	if whole == "" || hasFrac && (!fraction || frac == "") {
+		return false
+	}
+	return strings.Trim(whole+frac, "0123456789") == ""
+}
+
+// positionFrames converts the seconds of position str to frames, positions
+// past the range of the frame count are rejected.
+func positionFrames(str string, seconds float64, sampleRate int) (int64, error) {
+	if seconds*float64(sampleRate) >= 1<<62 {
+		return 0, fmt.Errorf("position %s is too large", str)
+	}
+	return secondsToFrames(seconds, sampleRate), nil
+}
+
+func secondsToFrames(seconds float64, sampleRate int) int64 {
+	return int64(math.Round(seconds * float64(sampleRate)))
+}
+
+// convertFrames converts a number of frames from one sample rate to another.
+func convertFrames(frames int64, fromRate, toRate int) int64 {
+	if fromRate == toRate {
+		return frames
+	}
+	return (frames*int64(toRate) + int64(fromRate)/2) / int64(fromRate)
+}
+
+// formatPosition formats a frame position as hh:mm:ss.mmm.
+func formatPosition(frames int64, sampleRate int) string {
+	ms := frames * 1000 / int64(sampleRate)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// readRangeFrames reads n frames starting at timeline frame pos, one slice
+// per channel. Frames outside the extracted range [start, end) are silent.
+func readRangeFrames(wavFiles []*WavFile, start, end, pos int64, n int) ([][]float64, error) {
+	frames := make([][]float64, wavFiles[0].NumChans)
+	for ch := range frames {
+		frames[ch] = make([]float64, n)
+	}
+
+	// the part within the range
+	first, last := max(pos, start), min(pos+int64(n), end)
+	if first >= last {
+		return frames, nil
+	}
+
+	dst := make([][]float64, len(frames))
+	for ch := range dst {
+		dst[ch] = frames[ch][first-pos : last-pos]
+	}
+	_, err := readTimelineFrames(wavFiles, first, dst)
+	return frames, err
+}
+
+// readTimelineFrames reads frames starting at timeline frame pos into dst,
+// one slice per channel, continuing into the next files as needed. It
+// returns the number of frames read.
+func readTimelineFrames(wavFiles []*WavFile, pos int64, dst [][]float64) (int, error) {
+	wavFilePositions := timelinePositions(wavFiles)
+	read := 0
+	for i, wavFile := range wavFiles {
+		if read == len(dst[0]) {
+			break
+		}
+
+		filePos := pos + int64(read) - wavFilePositions[i]
+		if filePos < 0 || filePos >= wavFile.Frames() {
+			continue
+		}
+
+		part := make([][]float64, len(dst))
+		for ch := range dst {
+			part[ch] = dst[ch][read:]
+		}
+
+		n, err := wavFile.ReadFramesAt(part, filePos)
+		read += n
+		if err != nil && err != io.EOF {
+			return read, fmt.Errorf("failed to read %s: %v", wavFile.Name, err)
+		}
+	}
+
+	return read, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParsePosition(t *testing.T) {
+	tests := []struct {
+		str    string
+		frames int64
+	}{
+		{"0", 0},
+		{"90", 90 * 48000},
+		{"90s", 90 * 48000},
+		{"90.5s", 90.5 * 48000},
+		{"1500ms", 72000},
+		{"0.5ms", 24},
+		{"4320000smp", 4320000},
+		{"1:30", 90 * 48000},
+		{"01:30.250", 90.25 * 48000},
+		{"01:30:00.000", 5400 * 48000},
+		{"100:00:00", 360000 * 48000},
+		{" 10s ", 10 * 48000},
+	}
+
+	for _, test := range tests {
+		frames, err := parsePosition(test.str, 48000)
+		if err != nil {
+			t.Errorf("parsePosition(%q): %v", test.str, err)
+			continue
+		}
+		if frames != test.frames {
+			t.Errorf("parsePosition(%q) = %d, want %d", test.str, frames, test.frames)
+		}
+	}
+
+	for _, str := range []string{
+		"", "abc", "-5", "-1s", "1:60", "1:-1", "1:2:3:4", "1.5:00", "1:30.5:00", "-1smp", "1.5smp", "-1ms", "xms", "10m",
+		// only digits with an optional fraction
+		"inf", "+Inf", "NaN", "nan", "infs", "1:inf", "infms", "NaNms", "1e1", "1e1s", "1E3ms", "0x10", "1:1e1",
+		"+5", "+5smp", ".5", "5.", "1_000", "99999999999999999999:00:00",
+	} {
+		if frames, err := parsePosition(str, 48000); err == nil {
+			t.Errorf("parsePosition(%q) = %d, want an error", str, frames)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	const total = 10 * 48000
+
+	tests := []struct {
+		start, end string
+		from, to   int64
+	}{
+		{"", "", 0, total},
+		{"2s", "", 2 * 48000, total},
+		{"", "00:05", 0, 5 * 48000},
+		{"1000smp", "2000smp", 1000, 2000},
+		{"5s", "1:00", 5 * 48000, total}, // the end is cut to the recording
+		{"9.999s", "", 479952, total},
+	}
+
+	for _, test := range tests {
+		from, to, err := parseRange(test.start, test.end, 48000, total)
+		if err != nil {
+			t.Errorf("parseRange(%q, %q): %v", test.start, test.end, err)
+			continue
+		}
+		if from != test.from || to != test.to {
+			t.Errorf("parseRange(%q, %q) = %d, %d, want %d, %d", test.start, test.end, from, to, test.from, test.to)
+		}
+	}
+
+	errors := [][2]string{
+		{"10s", ""},       // starts at the end
+		{"1:00", ""},      // starts past the end
+		{"5s", "5s"},      // empty
+		{"5s", "4s"},      // reversed
+		{"", "0"},         // empty
+		{"x", ""},         // invalid start
+		{"", "1:2:3:4"},   // invalid end
+		{"-1s", "2s"},     // negative
+		{"1s", "-2000ms"}, // negative
+		{"inf", ""},       // not finite
+		{"NaN", ""},       // not finite
+		{"", "inf"},       // not finite
+		{"1e1", ""},       // exponent
+	}
+	for _, test := range errors {
+		if from, to, err := parseRange(test[0], test[1], 48000, total); err == nil {
+			t.Errorf("parseRange(%q, %q) = %d, %d, want an error", test[0], test[1], from, to)
+		}
+	}
+}
@@ -1,55 +1,525 @@
 package main
 
 import (
+	"encoding/csv"
 	"fmt"
 	"github.com/calebmcelroy/wav-extract/wav"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode"
 )
 
+// TrackOptions configures the output files of the tracks.
+type TrackOptions struct {
+	OutputDir     string
+	AudioFormat   int
+	SampleRate    int
+	BitsPerSample int
+
+	// format of the input samples, converted to AudioFormat & BitsPerSample
+	// with Dither when they differ and resampled to SampleRate
+	SourceFormat        int
+	SourceBitsPerSample int
+	SourceSampleRate    int
+	Dither              string
+
+	// split tracks into segments of SegmentFrames frames or at most
+	// SegmentSize bytes, zero values don't split
+	SegmentFrames int64
+	SegmentSize   int64
+
+	// split tracks into a folder per split, cannot be combined with segments
+	Splits []Split
+
+	// markers written as cue points, in frames of the tracks
+	Markers []Marker
+
+	// track names by channel, used for the file names and INAM titles
+	Names ChannelNames
+
+	// file name template, see renderNameTemplate
+	NameTemplate string
+
+	// X-LIVE session name, for the {session} placeholder
+	Session string
+
+	// measure peak & RMS levels, e.g. for --skip-silent
+	Levels bool
+
+	// find runs of clipping & silence, see Events
+	DetectEvents bool
+
+	// measure levels, true peak & loudness for the report
+	Report bool
+
+	// frames of the extracted range at SourceSampleRate, delayed tracks are
+	// cut or padded with silence to keep this length
+	SourceFrames int64
+
+	// offsets in frames by zero-based channel, see Track.Delay
+	Delays map[int]int64
+}
+
 type Track struct {
+	opts     TrackOptions
+	mu       sync.Mutex
+	segments []*Segment
+	decode   wav.DecodeFunc
+	resample *resampleFilter // nil if the sample rate is kept
+	limit    bool            // soft limit the mix, see limitSample
+	stats    []channelStats
+	blocks   map[int64]loudnessBlock
+	events   []Event  // runs of clipping & silence, see Events
+	added    []Marker // markers added after the segments were sized, see AddMarkers
+
+	// format to convert a normalized bus to, see normalizeTrack
+	finalFormat int
+	finalBits   int
+
+	Name     string // base file name
+	Title    string // name from the name map, saved as INAM
+	Index    int    // one-based position of the track, seeds its dither
+	Channels []int
+
+	// gains of Channels in each output channel for mixed tracks, nil if
+	// Channels are the output channels
+	Matrix [][]float64
+
+	// frames the audio is moved later by, negative values move it earlier
+	Delay int64
+
+	// speaker layout of groups, nil for plain channels
+	Layout *Layout
+}
+
+// Segment is one output file of a track.
+type Segment struct {
 	writer *wav.Writer
 	file   *os.File
 
-	Name     string
-	Channels []int
+	Name  string // path relative to the output folder
+	Start int64  // first frame of the segment in the track
+	End   int64  // frame after the segment, math.MaxInt64 for the last segment
 }
 
+// Split is a part of the tracks saved in its own folder.
+type Split struct {
+	Start int64 // first frame of the split in the tracks
+	Dir   string
+}
+
+// WriteAt writes data at offset off of the track, splitting it across
+// segments at their frame boundaries.
 func (t *Track) WriteAt(p []byte, off int64) (n int, err error) {
-	return t.writer.WriteAt(p, off)
+	blockAlign := int64(t.blockAlign())
+
+	// delayed audio moved out of the track is dropped
+	if t.Delay != 0 {
+		off += t.Delay * blockAlign
+		if off < 0 {
+			skip := min(-off, int64(len(p)))
+			p = p[skip:]
+			off += skip
+			n += int(skip)
+		}
+		if end := t.frames() * blockAlign; off+int64(len(p)) > end {
+			keep := max(end-off, 0)
+			n += len(p) - int(keep)
+			p = p[:keep]
+		}
+	}
+
+	for len(p) > 0 {
+		segment, err := t.segment(off / blockAlign)
+		if err != nil {
+			return n, err
+		}
+
+		size := int64(len(p))
+		if segment.End != math.MaxInt64 {
+			size = min(size, segment.End*blockAlign-off)
+		}
+
+		written, err := segment.writer.WriteAt(p[:size], off-segment.Start*blockAlign)
+		n += written
+		if err != nil {
+			return n, err
+		}
+
+		p = p[size:]
+		off += size
+	}
+
+	return n, nil
 }
 
-func (t *Track) Close() error {
-	if err := t.writer.Close(); err != nil {
+// frames returns the length of the track.
+func (t *Track) frames() int64 {
+	if t.resample != nil {
+		return t.resample.outputFrames(t.opts.SourceFrames)
+	}
+	return t.opts.SourceFrames
+}
+
+// padDelay writes silence where a delayed track has no audio, at the start
+// for positive delays and at the end for negative ones.
+func (t *Track) padDelay() error {
+	if t.Delay == 0 {
+		return nil
+	}
+
+	encode, err := wav.Encoder(t.opts.AudioFormat, t.opts.BitsPerSample)
+	if err != nil {
 		return err
 	}
 
-	if err := t.file.Close(); err != nil {
+	blockAlign := t.blockAlign()
+	silence := make([]byte, min(max(t.Delay, -t.Delay), int64(t.opts.SampleRate))*int64(blockAlign))
+	bytesPerSample := t.opts.BitsPerSample / 8
+	for i := 0; i < len(silence); i += bytesPerSample {
+		encode(silence[i:], 0)
+	}
+
+	// positions before the shift of WriteAt
+	from, to := -t.Delay, int64(0)
+	if t.Delay < 0 {
+		from, to = t.frames(), t.frames()-t.Delay
+	}
+	for frame := from; frame < to; frame += int64(len(silence) / blockAlign) {
+		n := min(int64(len(silence)/blockAlign), to-frame)
+		if _, err := t.WriteAt(silence[:n*int64(blockAlign)], frame*int64(blockAlign)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newAnalyzer returns an analyzer of the track starting at track frame
+// frame, or nil if nothing is measured.
+func (t *Track) newAnalyzer(frame int64) *analyzer {
+	a := newAnalyzer(frame, t.numChans(), t.opts)
+	if a != nil {
+		a.setLayout(t.Layout)
+	}
+	return a
+}
+
+// measure decodes the frames in p into the analyzer.
+func (t *Track) measure(a *analyzer, p []byte) {
+	bytesPerSample := t.opts.BitsPerSample / 8
+	for i := 0; i+bytesPerSample <= len(p); i += bytesPerSample {
+		a.add(t.decode(p[i : i+bytesPerSample]))
+	}
+}
+
+// addAnalysis merges the levels measured by an analyzer into the track.
+func (t *Track) addAnalysis(a *analyzer) {
+	a.flush()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.events = append(t.events, a.events...)
+
+	for ch, stats := range a.channels {
+		t.stats[ch].merge(stats)
+	}
+
+	for i, block := range a.blocks {
+		index := a.firstBlock + int64(i)
+		merged := t.blocks[index]
+		merged.energy += block.energy
+		merged.frames += block.frames
+		t.blocks[index] = merged
+	}
+}
+
+// Peak returns the highest peak level of the track channels in dBFS. Unlike
+// Stats it doesn't need the loudness, e.g. for --skip-silent.
+func (t *Track) Peak() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	peak := 0.0
+	for _, stats := range t.stats {
+		peak = max(peak, stats.peak)
+	}
+	return decibels(peak)
+}
+
+// Stats returns the levels measured while extracting the track.
+func (t *Track) Stats() TrackStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var all channelStats
+	dcOffset := 0.0
+	for _, stats := range t.stats {
+		all.merge(stats)
+		if stats.samples > 0 {
+			dcOffset = max(dcOffset, math.Abs(stats.sum/float64(stats.samples)))
+		}
+	}
+
+	rms := 0.0
+	if all.samples > 0 {
+		rms = math.Sqrt(all.sumSquares / float64(all.samples))
+	}
+
+	loudness := math.Inf(-1)
+	if t.opts.Report {
+		loudness = integratedLoudness(t.blocks)
+	}
+
+	return TrackStats{
+		Peak:     decibels(all.peak),
+		TruePeak: decibels(all.truePeak),
+		RMS:      decibels(rms),
+		Loudness: loudness,
+		DCOffset: dcOffset,
+		Clips:    all.clips,
+	}
+}
+
+func (t *Track) Close() error {
+	for _, segment := range t.segments {
+		if segment == nil {
+			continue
+		}
+
+		segment.writer.SetCuePoints(t.segmentCuePoints(segment))
+		segment.writer.SetInfo(t.info())
+
+		if err := segment.writer.Close(); err != nil {
+			return err
+		}
+
+		if err := segment.file.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// segmentCuePoints returns the cue points of the markers within a segment.
+// Added markers that would take a segment past the segment size are left
+// out, from the last one.
+func (t *Track) segmentCuePoints(segment *Segment) []wav.CuePoint {
+	var added []Marker
+	for _, marker := range t.added {
+		if marker.Position >= segment.Start && marker.Position < segment.End {
+			added = append(added, marker)
+		}
+	}
+
+	// cue points with the first n added markers
+	cues := func(n int) []wav.CuePoint {
+		markers := append(append([]Marker(nil), t.opts.Markers...), added[:n]...)
+		sort.SliceStable(markers, func(i, j int) bool {
+			return markers[i].Position < markers[j].Position
+		})
+		return cuePoints(markers, segment.Start, segment.End)
+	}
+
+	fit := len(added)
+	if t.opts.SegmentSize > 0 {
+		room := t.opts.SegmentSize - segment.writer.Size()
+		fit = sort.Search(len(added)+1, func(n int) bool {
+			return t.chunksSize(t.opts, cues(n)) > room
+		}) - 1
+		fit = max(fit, 0)
+	}
+
+	if fit < len(added) {
+		fmt.Printf("\nWarning! %d cue points left out of %s to keep it within --segment-size.\n", len(added)-fit, segment.Name)
+	}
+	return cues(fit)
+}
+
+// Remove closes the track and deletes its files.
+func (t *Track) Remove() error {
+	if err := t.Close(); err != nil {
 		return err
 	}
 
+	for _, segment := range t.segments {
+		if segment == nil {
+			continue
+		}
+
+		if err := os.Remove(segment.file.Name()); err != nil {
+			return err
+		}
+
+		// folders of --split-on-markers left empty
+		dir := filepath.Dir(segment.file.Name())
+		if dir == filepath.Clean(t.opts.OutputDir) {
+			continue
+		}
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+			if err := os.Remove(dir); err != nil {
+				return err
+			}
+		}
+	}
+
+	t.segments = nil
 	return nil
 }
 
-func initTracks(stereoStr string, channelsStr string, outputDir string, numChans, bitDepth, sampleRate int) ([]*Track, error) {
+// newConverter returns a converter for the samples of the track starting at
+// track frame frame, or nil if the input samples are written as they are.
+func (t *Track) newConverter(frame int64) *converter {
+	if t.opts.SourceFormat == t.opts.AudioFormat && t.opts.SourceBitsPerSample == t.opts.BitsPerSample && !t.processed() {
+		return nil
+	}
+
+	// the formats are checked by newTrack
+	c, _ := newConverter(t.opts.SourceFormat, t.opts.SourceBitsPerSample, t.opts.AudioFormat, t.opts.BitsPerSample, t.opts.Dither, t.numChans())
+	c.seed(t.Index, frame)
+	return c
+}
+
+// processed returns whether the samples of the track are mixed, resampled
+// or limited, rather than copied or converted one by one.
+func (t *Track) processed() bool {
+	return t.Matrix != nil || t.resample != nil || t.limit
+}
+
+// numChans returns the number of output channels.
+func (t *Track) numChans() int {
+	if t.Matrix != nil {
+		return len(t.Matrix)
+	}
+	return len(t.Channels)
+}
+
+func (t *Track) blockAlign() int {
+	return t.numChans() * t.opts.BitsPerSample / 8
+}
+
+// newWriter returns a writer for a file of the track in the format of opts.
+// Groups are written as WAVE_FORMAT_EXTENSIBLE with their speaker positions.
+func (t *Track) newWriter(file *os.File, opts TrackOptions) *wav.Writer {
+	if t.Layout == nil && t.numChans() <= 2 {
+		return wav.NewWriter(file, opts.AudioFormat, t.numChans(), opts.SampleRate, opts.BitsPerSample)
+	}
+
+	mask := 0
+	if t.Layout != nil {
+		mask = t.Layout.Mask
+	}
+	return wav.NewExtensibleWriter(file, opts.AudioFormat, t.numChans(), opts.SampleRate, opts.BitsPerSample, mask)
+}
+
+// info returns the LIST INFO tags of the track files.
+func (t *Track) info() map[string]string {
+	if t.Title == "" {
+		return nil
+	}
+	return map[string]string{"INAM": t.Title}
+}
+
+// chunksSize returns the size of the chunks written after the audio of a
+// file of the track in the format of opts holding the cue points.
+func (t *Track) chunksSize(opts TrackOptions, cues []wav.CuePoint) int64 {
+	w := t.newWriter(nil, opts)
+	empty := w.Size()
+	w.SetCuePoints(cues)
+	w.SetInfo(t.info())
+	return w.Size() - empty
+}
+
+// segmentReserve returns the room a segment limited by size needs besides
+// its audio, the header and the chunks of a segment holding every marker.
+func (t *Track) segmentReserve(opts TrackOptions) int64 {
+	return t.newWriter(nil, opts).Size() + t.chunksSize(opts, cuePoints(opts.Markers, 0, math.MaxInt64))
+}
+
+// segment returns the segment holding frame, creating its file if needed.
+func (t *Track) segment(frame int64) (*Segment, error) {
+	index := 0
+	if t.opts.SegmentFrames > 0 {
+		index = int(frame / t.opts.SegmentFrames)
+	} else if len(t.opts.Splits) > 0 {
+		index = sort.Search(len(t.opts.Splits), func(i int) bool {
+			return t.opts.Splits[i].Start > frame
+		}) - 1
+		index = max(index, 0)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if index < len(t.segments) && t.segments[index] != nil {
+		return t.segments[index], nil
+	}
+
+	name := t.Name + ".wav"
+	start, end := int64(0), int64(math.MaxInt64)
+	if t.opts.SegmentFrames > 0 {
+		name = fmt.Sprintf("%s_part%03d.wav", t.Name, index+1)
+		start = int64(index) * t.opts.SegmentFrames
+		end = start + t.opts.SegmentFrames
+	} else if len(t.opts.Splits) > 0 {
+		name = filepath.Join(t.opts.Splits[index].Dir, name)
+		start = t.opts.Splits[index].Start
+		if index+1 < len(t.opts.Splits) {
+			end = t.opts.Splits[index+1].Start
+		}
+	}
+
+	outFilePath := filepath.Join(t.opts.OutputDir, name)
+	if err := os.MkdirAll(filepath.Dir(outFilePath), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create output folder '%s': %v", filepath.Dir(outFilePath), err)
+	}
+
+	outFile, err := os.Create(outFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file '%s': %v", outFilePath, err)
+	}
+
+	segment := &Segment{
+		writer: t.newWriter(outFile, t.opts),
+		file:   outFile,
+		Name:   name,
+		Start:  start,
+		End:    end,
+	}
+
+	for len(t.segments) <= index {
+		t.segments = append(t.segments, nil)
+	}
+	t.segments[index] = segment
+
+	return segment, nil
+}
+
+func initTracks(stereoStr string, channelsStr string, buses []Bus, numChans int, opts TrackOptions) ([]*Track, error) {
 	if stereoStr != "" && channelsStr != "" {
 		return nil, fmt.Errorf("both --stereo and --channels cannot be specified, choose just one")
 	}
 
 	var tracks []*Track
 
-	var channelPairs [][]int
+	var channelPairs []TrackSpec
+	var excluded []int
 	var err error
 
 	if stereoStr != "" {
-		channelPairs, err = parseChannelsString(stereoStr, numChans, false)
+		channelPairs, excluded, err = parseChannelsString(stereoStr, numChans, false)
 		if err != nil {
 			return nil, err
 		}
 	} else if channelsStr != "" {
-		channelPairs, err = parseChannelsString(channelsStr, numChans, true)
+		channelPairs, _, err = parseChannelsString(channelsStr, numChans, true)
 		if err != nil {
 			return nil, err
 		}
@@ -57,8 +527,8 @@ func initTracks(stereoStr string, channelsStr string, outputDir string, numChans
 
 	// Parse stereo pairs from the stereoStr
 	if channelPairs != nil && len(channelPairs) > 0 {
-		for _, channels := range channelPairs {
-			track, err := newTrack(channels, outputDir, sampleRate, bitDepth)
+		for _, spec := range channelPairs {
+			track, err := newTrack(len(tracks)+1, spec, opts)
 
 			if err != nil {
 				return nil, err
@@ -72,15 +542,18 @@ func initTracks(stereoStr string, channelsStr string, outputDir string, numChans
 		// Add mono tracks for any channels not included in stereo pairs
 
 		usedChannels := make(map[int]bool)
-		for _, channels := range channelPairs {
-			for _, channel := range channels {
+		for _, ch := range excluded {
+			usedChannels[ch+1] = true
+		}
+		for _, spec := range channelPairs {
+			for _, channel := range spec.Channels {
 				usedChannels[channel+1] = true
 			}
 		}
 
 		for ch := 1; ch <= numChans; ch++ {
 			if !usedChannels[ch] {
-				track, err := newTrack([]int{ch - 1}, outputDir, sampleRate, bitDepth)
+				track, err := newTrack(len(tracks)+1, TrackSpec{Channels: []int{ch - 1}, Gain: 1}, opts)
 				if err != nil {
 					return nil, err
 				}
@@ -89,86 +562,209 @@ func initTracks(stereoStr string, channelsStr string, outputDir string, numChans
 		}
 	}
 
-	return tracks, nil
-}
-
-func parseChannelsString(str string, numChans int, allowMono bool) ([][]int, error) {
-	usedChannels := make(map[int]bool)
-	var channels [][]int
-
-	pairs := strings.Split(str, ",")
-	for _, pairStr := range pairs {
-		channelsStr := strings.Split(pairStr, "/")
-		stereo := len(channelsStr) == 2
-		mono := len(channelsStr) == 1
+	for _, bus := range buses {
+		track, err := newBusTrack(len(tracks)+1, bus, opts)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
 
-		if len(channelsStr) != 2 && !(allowMono && mono) {
-			if allowMono {
-				return nil, fmt.Errorf("invalid stereo pair format: %s", pairStr)
-			}
-			return nil, fmt.Errorf("invalid stereo pair format: %s", pairStr)
+	// segments split at the same frames in every track, sized by the widest
+	// track and the largest header & chunks
+	if opts.SegmentSize > 0 {
+		reserve, blockAlign := int64(0), 0
+		for _, track := range tracks {
+			reserve = max(reserve, track.segmentReserve(opts))
+			blockAlign = max(blockAlign, track.blockAlign())
 		}
 
-		leftChan, err := strconv.Atoi(channelsStr[0])
-		if err != nil {
-			return nil, fmt.Errorf("invalid channel number: %s", channelsStr[0])
+		segmentFrames := (opts.SegmentSize - reserve) / int64(blockAlign)
+		if segmentFrames <= 0 {
+			return nil, fmt.Errorf("segment size %d is too small, the headers and cue points take %d bytes", opts.SegmentSize, reserve)
+		}
+		for _, track := range tracks {
+			track.opts.SegmentFrames = segmentFrames
 		}
+	}
 
-		var rightChan int
-		if stereo {
-			rightChan, err = strconv.Atoi(channelsStr[1])
-			if err != nil {
-				return nil, fmt.Errorf("invalid channel number: %s", channelsStr[1])
+	// tracks are delayed as a whole, so their channels need the same delay
+	for _, track := range tracks {
+		for i, ch := range track.Channels {
+			delay := opts.Delays[ch]
+			if i > 0 && delay != track.Delay {
+				return nil, fmt.Errorf("channels %d and %d of track %s have different delays, the channels of a stereo track or bus are delayed together and need the same --delay offset", track.Channels[0]+1, ch+1, track.Name)
 			}
+			track.Delay = delay
 		}
+	}
 
-		// Validate channels are within range
-		if leftChan < 1 || leftChan > numChans || (stereo && (rightChan < 1 || rightChan > numChans)) {
-			return nil, fmt.Errorf("channel numbers must be between 1 and %d", numChans)
+	// names from the name map or template may clash
+	fileNames := make(map[string]bool)
+	for _, track := range tracks {
+		key := strings.ToLower(track.Name)
+		if fileNames[key] {
+			return nil, fmt.Errorf("more than one track is named %s, check --names, --name-template and --bus", track.Name)
 		}
+		fileNames[key] = true
+	}
 
-		// Validate left != right
-		if stereo && leftChan == rightChan {
-			return nil, fmt.Errorf("left channel must be less than right channel in pair: %s", pairStr)
+	// create the first file up front so every track has one
+	for _, track := range tracks {
+		if _, err := track.segment(0); err != nil {
+			return nil, err
 		}
+	}
 
-		// Check for duplicate channels
-		if usedChannels[leftChan] || (stereo && usedChannels[rightChan]) {
-			return nil, fmt.Errorf("duplicate channel in pair: %s", pairStr)
-		}
-		usedChannels[leftChan] = true
-		usedChannels[rightChan] = true
+	return tracks, nil
+}
+
+func newTrack(index int, spec TrackSpec, opts TrackOptions) (*Track, error) {
+	channels := spec.Channels
 
-		if stereo {
-			channels = append(channels, []int{leftChan - 1, rightChan - 1})
-		} else {
-			channels = append(channels, []int{leftChan - 1})
+	var name string
+	switch {
+	case spec.Layout != nil:
+		parts := make([]string, len(channels))
+		for i, ch := range channels {
+			parts[i] = fmt.Sprintf("%d%s", ch+1, spec.Layout.Labels[i])
 		}
+		name = "track_" + strings.Join(parts, "_")
+	case len(channels) == 2:
+		name = fmt.Sprintf("track_%dL_%dR", channels[0]+1, channels[1]+1)
+	case len(channels) > 2:
+		name = "track_" + strings.ReplaceAll(channelsKey(channels), "/", "_")
+	default:
+		name = fmt.Sprintf("track_%d", channels[0]+1)
+	}
+
+	title := opts.Names[channelsKey(channels)]
+	if title != "" {
+		name = sanitizeFileName(title)
 	}
 
-	return channels, nil
+	track, err := newTrackNamed(index, channels, len(channels), name, title, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	track.Matrix = spec.matrix()
+	track.Layout = spec.Layout
+	return track, nil
 }
 
-func newTrack(channels []int, outputDir string, sampleRate, bitsPerSample int) (*Track, error) {
-	var name string
-	if len(channels) == 2 {
-		name = fmt.Sprintf("track_%dL_%dR.wav", channels[0]+1, channels[1]+1)
-	} else {
-		name = fmt.Sprintf("track_%d.wav", channels[0]+1)
+// newTrackNamed returns a track with numChans output channels taken from the
+// input channels, with its file name made from name by the name template.
+func newTrackNamed(index int, channels []int, numChans int, name, title string, opts TrackOptions) (*Track, error) {
+	template := opts.NameTemplate
+	if template == "" {
+		template = defaultNameTemplate
 	}
 
-	outFilePath := filepath.Join(outputDir, name)
-	outFile, err := os.Create(outFilePath)
+	name, err := renderNameTemplate(template, map[string]any{
+		"index":    index,
+		"name":     name,
+		"channel":  channels[0] + 1,
+		"channels": strings.ReplaceAll(channelsKey(channels), "/", "-"),
+		"session":  opts.Session,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create output file '%s': %v", outFilePath, err)
+		return nil, err
+	}
+
+	decode, err := wav.Decoder(opts.AudioFormat, opts.BitsPerSample)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := newConverter(opts.SourceFormat, opts.SourceBitsPerSample, opts.AudioFormat, opts.BitsPerSample, opts.Dither, numChans); err != nil {
+		return nil, err
+	}
+
+	var resample *resampleFilter
+	if opts.SourceSampleRate != opts.SampleRate {
+		resample, err = getResampleFilter(opts.SourceSampleRate, opts.SampleRate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	track := &Track{
+		opts:     opts,
+		decode:   decode,
+		resample: resample,
+		stats:    make([]channelStats, numChans),
+		blocks:   make(map[int64]loudnessBlock),
+		Name:     name,
+		Title:    title,
+		Index:    index,
+		Channels: channels, // Zero-based indexing
 	}
 
-	wavWriter := wav.NewWriter(outFile, 1, len(channels), sampleRate, bitsPerSample)
+	return track, nil
+}
+
+// writeSegmentIndex writes a CSV listing every segment of the tracks with its
+// start position on the recording, the extraction started at frame start of
+// the recording at sampleRate.
+func writeSegmentIndex(path string, tracks []*Track, start int64, sampleRate int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"track", "file", "start", "start_frame"})
+	for _, track := range tracks {
+		for _, segment := range track.segments {
+			if segment == nil {
+				continue
+			}
+
+			// positions on the recording, tracks may be resampled
+			position := start + convertFrames(segment.Start, track.opts.SampleRate, sampleRate)
+			w.Write([]string{
+				track.Name,
+				segment.Name,
+				formatPosition(position, sampleRate),
+				strconv.FormatInt(position, 10),
+			})
+		}
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+// sanitizeFileName replaces characters that are not safe in file names.
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" -_.()", r) {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(name))
+}
+
+// parseSize parses a size in bytes with an optional K, M or G suffix (e.g. 2G, 700MB).
+func parseSize(sizeStr string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(sizeStr))
+	str = strings.TrimSuffix(str, "B")
+
+	multiplier := int64(1)
+	for i, suffix := range []string{"K", "M", "G"} {
+		if number, ok := strings.CutSuffix(str, suffix); ok {
+			str = number
+			multiplier = int64(1) << (10 * (i + 1))
+			break
+		}
+	}
+
+	size, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid size: %s", sizeStr)
+	}
 
-	return &Track{
-		wavWriter,
-		outFile,
-		name,
-		channels, // Zero-based indexing
-	}, nil
+	return int64(size * float64(multiplier)), nil
 }
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/calebmcelroy/wav-extract/wav"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testTrackOptions returns options for 16-bit 48 kHz tracks of a recording
+// of frames frames in the same format.
+func testTrackOptions(dir string, frames int64) TrackOptions {
+	return TrackOptions{
+		OutputDir:           dir,
+		AudioFormat:         wav.FormatPCM,
+		SampleRate:          48000,
+		BitsPerSample:       16,
+		SourceFormat:        wav.FormatPCM,
+		SourceBitsPerSample: 16,
+		SourceSampleRate:    48000,
+		SourceFrames:        frames,
+	}
+}
+
+// readTestWav reads the frames of a wav file, one slice per channel.
+func readTestWav(t *testing.T, path string) ([][]float64, []wav.CuePoint) {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	r := wav.NewReader(file)
+	if err := r.ReadHeader(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := make([][]float64, r.NumChans)
+	for ch := range frames {
+		frames[ch] = make([]float64, r.Frames())
+	}
+	if r.Frames() > 0 {
+		if _, err := r.ReadFrames(frames); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+
+	cues, err := r.CuePoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return frames, cues
+}
+
+// writeTestWav writes frames to a new wav file, one slice per channel.
+func writeTestWav(t *testing.T, path string, audioFormat, bitsPerSample, sampleRate int, frames [][]float64) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	encode, err := wav.Encoder(audioFormat, bitsPerSample)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockAlign := len(frames) * bitsPerSample / 8
+	buf := make([]byte, len(frames[0])*blockAlign)
+	for i := range frames[0] {
+		for ch := range frames {
+			encode(buf[i*blockAlign+ch*bitsPerSample/8:], frames[ch][i])
+		}
+	}
+
+	w := wav.NewWriter(file, audioFormat, len(frames), sampleRate, bitsPerSample)
+	if _, err := w.WriteAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openTestWavs writes frames as consecutive 32-bit float files of a recording,
+// split into files of the given lengths, and opens them.
+func openTestWavs(t *testing.T, sampleRate int, frames [][]float64, lengths ...int) []*WavFile {
+	t.Helper()
+
+	dir := t.TempDir()
+	var paths []string
+	pos := 0
+	for i, length := range lengths {
+		file := make([][]float64, len(frames))
+		for ch := range file {
+			file[ch] = frames[ch][pos : pos+length]
+		}
+		pos += length
+
+		path := filepath.Join(dir, fmt.Sprintf("%08X.WAV", i+1))
+		writeTestWav(t, path, wav.FormatIEEEFloat, 32, sampleRate, file)
+		paths = append(paths, path)
+	}
+
+	wavFiles, err := initReaders(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, wavFile := range wavFiles {
+			wavFile.Close()
+		}
+	})
+
+	return wavFiles
+}
+
+// extractTestTracks extracts the whole recording to the tracks and closes them.
+func extractTestTracks(t *testing.T, wavFiles []*WavFile, tracks []*Track) {
+	t.Helper()
+
+	extract(context.Background(), wavFiles, tracks, 0, timelineFrames(wavFiles), time.Millisecond, func(Progress) {})
+	for _, track := range tracks {
+		if err := track.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeTrackFrames writes frames to a track starting at frame off, one slice
+// per channel.
+func writeTrackFrames(t *testing.T, track *Track, frames [][]float64, off int64) {
+	t.Helper()
+
+	encode, err := wav.Encoder(track.opts.AudioFormat, track.opts.BitsPerSample)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockAlign := track.blockAlign()
+	bytesPerSample := track.opts.BitsPerSample / 8
+	buf := make([]byte, len(frames[0])*blockAlign)
+	for i := range frames[0] {
+		for ch := range frames {
+			encode(buf[i*blockAlign+ch*bytesPerSample:], frames[ch][i])
+		}
+	}
+
+	if _, err := track.WriteAt(buf, off*int64(blockAlign)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		str  string
+		size int64
+	}{
+		{"1000", 1000},
+		{"1000B", 1000},
+		{"64K", 64 << 10},
+		{"64kb", 64 << 10},
+		{"700MB", 700 << 20},
+		{"2G", 2 << 30},
+		{"1.5G", 3 << 29},
+		{" 2 GB ", 2 << 30},
+	}
+
+	for _, test := range tests {
+		size, err := parseSize(test.str)
+		if err != nil {
+			t.Errorf("parseSize(%q): %v", test.str, err)
+			continue
+		}
+		if size != test.size {
+			t.Errorf("parseSize(%q) = %d, want %d", test.str, size, test.size)
+		}
+	}
+
+	for _, str := range []string{"", "G", "-1G", "0", "2T", "two"} {
+		if size, err := parseSize(str); err == nil {
+			t.Errorf("parseSize(%q) = %d, want an error", str, size)
+		}
+	}
+}
+
+func TestSegmentRollover(t *testing.T) {
+	dir := t.TempDir()
+	opts := testTrackOptions(dir, 250)
+	opts.SegmentFrames = 100
+	opts.Markers = []Marker{{50, "A"}, {100, "B"}, {230, "C"}}
+
+	tracks, err := initTracks("", "1", nil, 2, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one write across both boundaries
+	frames := make([]float64, 250)
+	for i := range frames {
+		frames[i] = float64(i) / (1 << 15)
+	}
+	writeTrackFrames(t, tracks[0], [][]float64{frames}, 0)
+	if err := tracks[0].Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		first int
+		cues  []wav.CuePoint
+	}{
+		{"track_1_part001.wav", 0, []wav.CuePoint{{ID: 1, Position: 50, Label: "A"}}},
+		{"track_1_part002.wav", 100, []wav.CuePoint{{ID: 1, Position: 0, Label: "B"}}},
+		{"track_1_part003.wav", 200, []wav.CuePoint{{ID: 1, Position: 30, Label: "C"}}},
+	}
+
+	for _, test := range tests {
+		segment, cues := readTestWav(t, filepath.Join(dir, test.name))
+		if want := min(100, 250-test.first); len(segment[0]) != want {
+			t.Errorf("%s has %d frames, want %d", test.name, len(segment[0]), want)
+			continue
+		}
+		if segment[0][0] != frames[test.first] || segment[0][len(segment[0])-1] != frames[test.first+len(segment[0])-1] {
+			t.Errorf("%s doesn't start at frame %d", test.name, test.first)
+		}
+		if fmt.Sprint(cues) != fmt.Sprint(test.cues) {
+			t.Errorf("%s cue points = %v, want %v", test.name, cues, test.cues)
+		}
+	}
+}
+
+func TestSegmentSize(t *testing.T) {
+	const segmentSize = 16 << 10
+
+	dir := t.TempDir()
+	opts := testTrackOptions(dir, 48000)
+	opts.SegmentSize = segmentSize
+
+	// more cue points & labels than a fixed reserve of a few KB holds
+	for i := range 200 {
+		opts.Markers = append(opts.Markers, Marker{int64(i * 20), fmt.Sprintf("Marker with a fairly long label %03d", i)})
+	}
+	opts.Names = ChannelNames{"1/2": "Overheads"}
+
+	tracks, err := initTracks("1/2", "", nil, 3, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// segments split at the same frames in every track
+	if tracks[0].opts.SegmentFrames != tracks[1].opts.SegmentFrames {
+		t.Fatalf("segment frames differ: %d and %d", tracks[0].opts.SegmentFrames, tracks[1].opts.SegmentFrames)
+	}
+
+	for _, track := range tracks {
+		channels := make([][]float64, len(track.Channels))
+		for ch := range channels {
+			channels[ch] = make([]float64, 48000)
+		}
+		writeTrackFrames(t, track, channels, 0)
+
+		// markers added after the segments were sized, e.g. events
+		var events []Marker
+		for i := range 10000 {
+			events = append(events, Marker{int64(i), "Clipping ch 1 (event)"})
+		}
+		track.AddMarkers(events)
+
+		if err := track.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, track := range tracks {
+		if len(track.segments) < 2 {
+			t.Errorf("%s has %d segments, want several", track.Name, len(track.segments))
+		}
+
+		for _, segment := range track.segments {
+			info, err := os.Stat(filepath.Join(dir, segment.Name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() > segmentSize {
+				t.Errorf("%s is %d bytes, more than the segment size %d", segment.Name, info.Size(), segmentSize)
+			}
+		}
+	}
+
+	// the markers known up front all fit
+	_, cues := readTestWav(t, filepath.Join(dir, "Overheads_part001.wav"))
+	markers := 0
+	for _, cue := range cues {
+		if cue.Label != "Clipping ch 1 (event)" {
+			markers++
+		}
+	}
+	if want := len(markersInRange(opts.Markers, 0, tracks[0].opts.SegmentFrames)); markers != want {
+		t.Errorf("first segment has %d markers, want %d", markers, want)
+	}
+
+	if _, err := initTracks("", "1", nil, 1, TrackOptions{SegmentSize: 1000, Markers: opts.Markers, AudioFormat: wav.FormatPCM, BitsPerSample: 16, SourceFormat: wav.FormatPCM, SourceBitsPerSample: 16, SourceSampleRate: 48000}); err == nil {
+		t.Error("initTracks with a segment size smaller than the cue points succeeded")
+	}
+}
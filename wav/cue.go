@@ -0,0 +1,165 @@
+package wav
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CuePoint marks a frame of the data chunk, it is stored in the cue chunk
+// with its label in a LIST adtl chunk.
+type CuePoint struct {
+	ID       int
+	Position int64 // frame in the data chunk
+	Label    string
+}
+
+// SetCuePoints adds cue and LIST adtl chunks for the cue points, written after
+// the data chunk on Close. Positions past the 32-bit limit are left out.
+func (w *Writer) SetCuePoints(cues []CuePoint) {
+	le := binary.LittleEndian
+
+	var cueData []byte
+	adtlData := []byte("adtl")
+	count := 0
+	for _, cue := range cues {
+		if cue.Position < 0 || cue.Position > maxRIFFSize {
+			continue
+		}
+		count++
+
+		// id, position, data chunk id, chunk start, block start, sample offset
+		cueData = le.AppendUint32(cueData, uint32(cue.ID))
+		cueData = le.AppendUint32(cueData, uint32(cue.Position))
+		cueData = append(cueData, "data"...)
+		cueData = le.AppendUint32(cueData, 0)
+		cueData = le.AppendUint32(cueData, 0)
+		cueData = le.AppendUint32(cueData, uint32(cue.Position))
+
+		if cue.Label != "" {
+			labl := le.AppendUint32(nil, uint32(cue.ID))
+			labl = append(labl, cue.Label...)
+			labl = append(labl, 0)
+			adtlData = append(adtlData, chunkBytes("labl", labl)...)
+		}
+	}
+
+	if count == 0 {
+		return
+	}
+
+	w.AddChunk("cue ", le.AppendUint32(nil, uint32(count)), cueData)
+	if len(adtlData) > 4 {
+		w.AddChunk("LIST", adtlData)
+	}
+}
+
+// AddChunk adds a chunk holding the concatenated data, written after the
+// data chunk on Close.
+func (w *Writer) AddChunk(id string, data ...[]byte) {
+	var chunkData []byte
+	for _, d := range data {
+		chunkData = append(chunkData, d...)
+	}
+
+	w.trailingChunks = append(w.trailingChunks, chunkBytes(id, chunkData)...)
+}
+
+// chunkBytes returns a chunk with its header and pad byte.
+func chunkBytes(id string, data []byte) []byte {
+	chunk := make([]byte, 8, 8+len(data)+1)
+	copy(chunk, id)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// CuePoints returns the cue points of the cue chunk with their labels from
+// the LIST adtl chunk. The source must be an io.Seeker.
+func (r *Reader) CuePoints() ([]CuePoint, error) {
+	if _, ok := r.r.(io.Seeker); !ok {
+		return nil, fmt.Errorf("source does not implement io.Seeker")
+	}
+
+	chunks, err := r.Chunks()
+	if err != nil {
+		return nil, err
+	}
+
+	var cues []CuePoint
+	labels := make(map[int]string)
+	for _, chunk := range chunks {
+		// LIST chunks hold other metadata too, only small ones can be adtl
+		if (chunk.ID != "cue " && chunk.ID != "LIST") || chunk.Size > maxHeaderChunkSize {
+			continue
+		}
+
+		data, err := r.readChunkAt(chunk)
+		if err != nil {
+			return nil, err
+		}
+
+		if chunk.ID == "cue " {
+			if len(data) < 4 {
+				return nil, fmt.Errorf("invalid cue chunk size")
+			}
+
+			// id, position, data chunk id, chunk start, block start, sample offset
+			count := int(binary.LittleEndian.Uint32(data[0:4]))
+			for i := 0; i < count && 4+i*24+24 <= len(data); i++ {
+				entry := data[4+i*24:]
+				cues = append(cues, CuePoint{
+					ID:       int(binary.LittleEndian.Uint32(entry[0:4])),
+					Position: int64(binary.LittleEndian.Uint32(entry[20:24])),
+				})
+			}
+
+			continue
+		}
+
+		if len(data) < 4 || string(data[:4]) != "adtl" {
+			continue
+		}
+
+		// labl sub chunks hold a cue id and a null terminated label
+		for off := 4; off+8 <= len(data); {
+			id := string(data[off : off+4])
+			size := int(binary.LittleEndian.Uint32(data[off+4 : off+8]))
+			sub := data[off+8 : min(off+8+size, len(data))]
+			if id == "labl" && len(sub) >= 4 {
+				label, _, _ := strings.Cut(string(sub[4:]), "\x00")
+				labels[int(binary.LittleEndian.Uint32(sub[0:4]))] = label
+			}
+			off += 8 + size + size%2
+		}
+	}
+
+	for i := range cues {
+		cues[i].Label = labels[cues[i].ID]
+	}
+
+	return cues, nil
+}
+
+// readChunkAt reads the data of a chunk and returns to the read position.
+func (r *Reader) readChunkAt(chunk Chunk) ([]byte, error) {
+	pos := r.pos
+	if err := r.seekTo(chunk.Offset); err != nil {
+		return nil, err
+	}
+
+	data, err := r.readChunkData(chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.seekTo(pos); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
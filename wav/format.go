@@ -0,0 +1,47 @@
+package wav
+
+// audio format tags
+const (
+	FormatPCM        = 0x0001
+	FormatIEEEFloat  = 0x0003
+	FormatExtensible = 0xFFFE
+)
+
+// speaker positions of the WAVE_FORMAT_EXTENSIBLE channel mask
+const (
+	SpeakerFrontLeft    = 0x1
+	SpeakerFrontRight   = 0x2
+	SpeakerFrontCenter  = 0x4
+	SpeakerLowFrequency = 0x8
+	SpeakerBackLeft     = 0x10
+	SpeakerBackRight    = 0x20
+	SpeakerSideLeft     = 0x200
+	SpeakerSideRight    = 0x400
+)
+
+// subFormatSuffix is shared by the KSDATAFORMAT_SUBTYPE_* GUIDs that wrap a
+// plain format tag, which fills the first two bytes of the GUID.
+var subFormatSuffix = [14]byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}
+
+// subFormatGUID returns the SubFormat GUID for a plain format tag.
+func subFormatGUID(format int) [16]byte {
+	var guid [16]byte
+	guid[0] = byte(format)
+	guid[1] = byte(format >> 8)
+	copy(guid[2:], subFormatSuffix[:])
+	return guid
+}
+
+// SampleFormat returns the format tag of the samples. For WAVE_FORMAT_EXTENSIBLE
+// files it is taken from the SubFormat GUID, or 0 if the GUID is not a known one.
+func (r *Reader) SampleFormat() int {
+	if r.AudioFormat != FormatExtensible {
+		return r.AudioFormat
+	}
+
+	if [14]byte(r.SubFormat[2:]) != subFormatSuffix {
+		return 0
+	}
+
+	return int(r.SubFormat[0]) | int(r.SubFormat[1])<<8
+}
@@ -0,0 +1,285 @@
+package wav
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// DecodeFunc decodes one sample to a float64, full scale is [-1, 1).
+type DecodeFunc func(b []byte) float64
+
+// EncodeFunc encodes one float64 sample, integer formats are rounded and clipped.
+type EncodeFunc func(b []byte, v float64)
+
+// Decoder returns the DecodeFunc for samples of the given format and size.
+func Decoder(format, bitsPerSample int) (DecodeFunc, error) {
+	switch {
+	case format == FormatPCM && bitsPerSample == 8:
+		return func(b []byte) float64 {
+			return float64(int(b[0])-128) / 128
+		}, nil
+	case format == FormatPCM && bitsPerSample == 16:
+		return func(b []byte) float64 {
+			return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+		}, nil
+	case format == FormatPCM && bitsPerSample == 24:
+		return func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}, nil
+	case format == FormatPCM && bitsPerSample == 32:
+		return func(b []byte) float64 {
+			return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+		}, nil
+	case format == FormatIEEEFloat && bitsPerSample == 32:
+		return func(b []byte) float64 {
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		}, nil
+	case format == FormatIEEEFloat && bitsPerSample == 64:
+		return func(b []byte) float64 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported sample format 0x%04X with %d bits per sample", format, bitsPerSample)
+}
+
+// Encoder returns the EncodeFunc for samples of the given format and size.
+func Encoder(format, bitsPerSample int) (EncodeFunc, error) {
+	switch {
+	case format == FormatPCM && bitsPerSample == 8:
+		return func(b []byte, v float64) {
+			b[0] = byte(quantize(v, 8) + 128)
+		}, nil
+	case format == FormatPCM && bitsPerSample == 16:
+		return func(b []byte, v float64) {
+			binary.LittleEndian.PutUint16(b, uint16(quantize(v, 16)))
+		}, nil
+	case format == FormatPCM && bitsPerSample == 24:
+		return func(b []byte, v float64) {
+			s := quantize(v, 24)
+			b[0], b[1], b[2] = byte(s), byte(s>>8), byte(s>>16)
+		}, nil
+	case format == FormatPCM && bitsPerSample == 32:
+		return func(b []byte, v float64) {
+			binary.LittleEndian.PutUint32(b, uint32(quantize(v, 32)))
+		}, nil
+	case format == FormatIEEEFloat && bitsPerSample == 32:
+		return func(b []byte, v float64) {
+			binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+		}, nil
+	case format == FormatIEEEFloat && bitsPerSample == 64:
+		return func(b []byte, v float64) {
+			binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported sample format 0x%04X with %d bits per sample", format, bitsPerSample)
+}
+
+// quantize rounds v to a signed integer of the given size, clipping at full scale.
+func quantize(v float64, bits int) int64 {
+	scale := float64(int64(1) << (bits - 1))
+	s := math.Round(v * scale)
+	if s > scale-1 {
+		return int64(scale - 1)
+	}
+	if s < -scale {
+		return int64(-scale)
+	}
+	return int64(s)
+}
+
+// ReadFrames reads up to len(dst[0]) frames into dst, one slice per channel,
+// decoded to full scale [-1, 1). It returns the number of frames read and
+// io.EOF once the data chunk is exhausted.
+func (r *Reader) ReadFrames(dst [][]float64) (n int, err error) {
+	if err := r.ReadHeader(); err != nil {
+		return 0, err
+	}
+
+	if r.decode == nil {
+		r.decode, err = Decoder(r.SampleFormat(), r.BitsPerSample)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	frames, err := frameCount(r.NumChans, dst)
+	if err != nil || frames == 0 {
+		return 0, err
+	}
+
+	buf, n, err := r.readFrameBytes(frames)
+
+	r.decodeFrames(dst, buf, n, r.decode)
+
+	return n, err
+}
+
+// ReadInt32Frames reads up to len(dst[0]) frames into dst like ReadFrames.
+// Integer samples are left aligned to 32 bits, float samples are scaled to
+// full scale 32-bit integers and clipped.
+func (r *Reader) ReadInt32Frames(dst [][]int32) (n int, err error) {
+	if err := r.ReadHeader(); err != nil {
+		return 0, err
+	}
+
+	format := r.SampleFormat()
+	if format == FormatIEEEFloat && r.decode == nil {
+		r.decode, err = Decoder(format, r.BitsPerSample)
+		if err != nil {
+			return 0, err
+		}
+	} else if format != FormatIEEEFloat && (format != FormatPCM || r.BitsPerSample%8 != 0 || r.BitsPerSample > 32) {
+		return 0, fmt.Errorf("unsupported sample format 0x%04X with %d bits per sample", format, r.BitsPerSample)
+	}
+
+	frames, err := frameCount(r.NumChans, dst)
+	if err != nil || frames == 0 {
+		return 0, err
+	}
+
+	buf, n, err := r.readFrameBytes(frames)
+
+	bytesPerSample := r.BlockAlign / r.NumChans
+	for i := 0; i < n; i++ {
+		frame := buf[i*r.BlockAlign:]
+		for ch := range dst {
+			b := frame[ch*bytesPerSample : (ch+1)*bytesPerSample]
+			switch {
+			case format == FormatIEEEFloat:
+				dst[ch][i] = int32(quantize(r.decode(b), 32))
+			case r.BitsPerSample == 8:
+				dst[ch][i] = int32(int8(b[0]^0x80)) << 24
+			default:
+				// assemble little-endian bytes into the top of the int32
+				var s uint32
+				for j, c := range b {
+					s |= uint32(c) << (32 - 8*(len(b)-j))
+				}
+				dst[ch][i] = int32(s)
+			}
+		}
+	}
+
+	return n, err
+}
+
+// ReadFramesAt reads up to len(dst[0]) frames starting at frame off into dst
+// like ReadFrames, using ReadAt. It is safe to call concurrently.
+func (r *Reader) ReadFramesAt(dst [][]float64, off int64) (n int, err error) {
+	// the header can't be read here as calls may run concurrently
+	if !r.headerRead {
+		return 0, fmt.Errorf("header not read, call ReadHeader before ReadFramesAt")
+	}
+
+	r.decodeAtOnce.Do(func() {
+		r.decodeAt, r.decodeAtErr = Decoder(r.SampleFormat(), r.BitsPerSample)
+	})
+	if r.decodeAtErr != nil {
+		return 0, r.decodeAtErr
+	}
+
+	frames, err := frameCount(r.NumChans, dst)
+	if err != nil || frames == 0 {
+		return 0, err
+	}
+
+	buf := make([]byte, frames*r.BlockAlign)
+	read, err := r.ReadAt(buf, off*int64(r.BlockAlign))
+	n = read / r.BlockAlign
+	if n > 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+		err = nil
+	}
+
+	r.decodeFrames(dst, buf, n, r.decodeAt)
+
+	return n, err
+}
+
+// decodeFrames decodes n interleaved frames of buf into the per channel slices of dst.
+func (r *Reader) decodeFrames(dst [][]float64, buf []byte, n int, decode DecodeFunc) {
+	bytesPerSample := r.BlockAlign / r.NumChans
+	for i := 0; i < n; i++ {
+		frame := buf[i*r.BlockAlign:]
+		for ch := range dst {
+			dst[ch][i] = decode(frame[ch*bytesPerSample:])
+		}
+	}
+}
+
+// frameCount returns the number of frames that fit in the per channel slices.
+func frameCount[T any](numChans int, chans [][]T) (int, error) {
+	if len(chans) != numChans {
+		return 0, fmt.Errorf("got %d channel slices, expected %d", len(chans), numChans)
+	}
+
+	frames := len(chans[0])
+	for _, ch := range chans {
+		frames = min(frames, len(ch))
+	}
+
+	return frames, nil
+}
+
+// readFrameBytes reads up to frames whole frames and returns them with their
+// count. Reaching the end of the data is only an error if no frame was read.
+func (r *Reader) readFrameBytes(frames int) ([]byte, int, error) {
+	if cap(r.frameBuf) < frames*r.BlockAlign {
+		r.frameBuf = make([]byte, frames*r.BlockAlign)
+	}
+	buf := r.frameBuf[:frames*r.BlockAlign]
+
+	// a partial frame at the end of the data is dropped
+	n, err := io.ReadFull(r, buf)
+	n /= r.BlockAlign
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+		if n == 0 {
+			err = io.EOF
+		}
+	}
+
+	return buf, n, err
+}
+
+// WriteFrames encodes the frames of src, one slice per channel, and writes
+// them starting at frame off of the data chunk. Like WriteAt it is safe to
+// call concurrently for non-overlapping ranges.
+func (w *Writer) WriteFrames(src [][]float64, off int64) (n int, err error) {
+	w.encodeOnce.Do(func() {
+		w.encode, w.encodeErr = Encoder(w.audioFormat, w.bitsPerSample)
+	})
+	if w.encodeErr != nil {
+		return 0, w.encodeErr
+	}
+
+	frames, err := frameCount(w.numChans, src)
+	if err != nil || frames == 0 {
+		return 0, err
+	}
+
+	// buffers are pooled as calls may run concurrently
+	blockAlign := int(w.blockAlign())
+	bufp, _ := w.frameBufs.Get().(*[]byte)
+	if bufp == nil || cap(*bufp) < frames*blockAlign {
+		buf := make([]byte, frames*blockAlign)
+		bufp = &buf
+	}
+	defer w.frameBufs.Put(bufp)
+	buf := (*bufp)[:frames*blockAlign]
+
+	bytesPerSample := w.bitsPerSample / 8
+	for i := 0; i < frames; i++ {
+		frame := buf[i*blockAlign:]
+		for ch := range src {
+			w.encode(frame[ch*bytesPerSample:], src[ch][i])
+		}
+	}
+
+	written, err := w.WriteAt(buf, off*int64(blockAlign))
+
+	return written / blockAlign, err
+}
@@ -0,0 +1,24 @@
+package wav
+
+import (
+	"sort"
+)
+
+// SetInfo adds a LIST INFO chunk with the given tags (e.g. INAM for the
+// title), written after the data chunk on Close.
+func (w *Writer) SetInfo(info map[string]string) {
+	ids := make([]string, 0, len(info))
+	for id := range info {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	infoData := []byte("INFO")
+	for _, id := range ids {
+		infoData = append(infoData, chunkBytes(id, append([]byte(info[id]), 0))...)
+	}
+
+	if len(ids) > 0 {
+		w.AddChunk("LIST", infoData)
+	}
+}
@@ -4,12 +4,45 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sync"
 )
 
+// largest fmt/ds64 chunk accepted, guards against allocating corrupt sizes
+const maxHeaderChunkSize = 1 << 16
+
+// Chunk describes a chunk of a RIFF file.
+type Chunk struct {
+	ID     string
+	Offset int64 // offset of the chunk data in the file
+	Size   int64 // size of the chunk data, without the pad byte
+}
+
+// end returns the offset of the next chunk, chunks are word aligned.
+func (c Chunk) end() int64 {
+	return c.Offset + c.Size + c.Size%2
+}
+
 type Reader struct {
 	r          io.Reader
+	data       *io.LimitedReader
+	pos        int64
 	headerRead bool
 
+	riffSize        int64
+	ds64Sizes       map[string]int64
+	ds64SampleCount int64
+	chunks          []Chunk
+	dataChunk       Chunk
+	chunksRead      bool
+
+	decode   DecodeFunc
+	frameBuf []byte
+
+	// decoder of ReadFramesAt, which may be called concurrently
+	decodeAtOnce sync.Once
+	decodeAt     DecodeFunc
+	decodeAtErr  error
+
 	AudioFormat   int
 	NumChans      int
 	SampleRate    int
@@ -17,6 +50,16 @@ type Reader struct {
 	BlockAlign    int
 	BitsPerSample int
 	DataSize      int
+
+	// number of sample frames from the fact chunk, 0 if there is none
+	SampleLength int
+
+	// WAVE_FORMAT_EXTENSIBLE fields, ValidBitsPerSample is BitsPerSample and
+	// the rest are zero for plain fmt chunks
+	CbSize             int
+	ValidBitsPerSample int
+	ChannelMask        int
+	SubFormat          [16]byte
 }
 
 func NewReader(r io.Reader) *Reader {
@@ -31,7 +74,11 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 		}
 	}
 
-	return r.r.Read(p)
+	// reads stop at the end of the data chunk, chunks after it are not audio
+	n, err = r.data.Read(p)
+	r.pos += int64(n)
+
+	return n, err
 }
 
 func (r *Reader) ReadHeader() error {
@@ -41,13 +88,13 @@ func (r *Reader) ReadHeader() error {
 
 	// read RIFF header
 	riffHeader := make([]byte, 12)
-	_, err := r.r.Read(riffHeader)
-	if err != nil {
+	if err := r.readFull(riffHeader); err != nil {
 		return err
 	}
 
-	// validate RIFF header
-	if string(riffHeader[:4]) != "RIFF" {
+	// validate RIFF header, RF64 & BW64 are RIFF with 64-bit sizes in a ds64 chunk
+	riffID := string(riffHeader[:4])
+	if riffID != "RIFF" && riffID != "RF64" && riffID != "BW64" {
 		return fmt.Errorf("invalid RIFF header")
 	}
 
@@ -55,63 +102,321 @@ func (r *Reader) ReadHeader() error {
 		return fmt.Errorf("invalid WAVE header")
 	}
 
-	// read fmt header
-	fmtData := make([]byte, 24)
-	_, err = r.r.Read(fmtData)
+	r.riffSize = int64(binary.LittleEndian.Uint32(riffHeader[4:8]))
+
+	fmtRead := false
+
+	// walk chunks until data chunk, fmt & ds64 may be anywhere before it
+	for {
+		chunk, err := r.nextChunk()
+		if err == io.EOF {
+			return fmt.Errorf("data chunk not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		r.chunks = append(r.chunks, chunk)
+
+		switch chunk.ID {
+		case "ds64":
+			if riffID == "RIFF" {
+				return fmt.Errorf("unexpected ds64 chunk in RIFF file")
+			}
+
+			if err = r.readDS64(chunk); err != nil {
+				return err
+			}
+		case "fmt ":
+			if err = r.readFmt(chunk); err != nil {
+				return err
+			}
+
+			fmtRead = true
+		case "fact":
+			if err = r.readFact(chunk); err != nil {
+				return err
+			}
+		case "data":
+			if !fmtRead {
+				return fmt.Errorf("fmt chunk not found before data chunk")
+			}
+
+			// 0xFFFFFFFF means the real size is in the ds64 chunk
+			if riffID != "RIFF" && chunk.Size == maxRIFFSize && r.ds64Sizes == nil {
+				return fmt.Errorf("ds64 chunk not found")
+			}
+
+			// found data chunk!
+			r.dataChunk = chunk
+			r.DataSize = int(chunk.Size)
+			r.data = &io.LimitedReader{R: r.r, N: chunk.Size}
+			r.headerRead = true
+
+			return nil
+		default:
+			if err = r.seekTo(chunk.end()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Chunks returns every chunk of the file in order. Chunks after the data chunk
+// are only found when the source is an io.Seeker. The read position is kept.
+func (r *Reader) Chunks() ([]Chunk, error) {
+	if err := r.ReadHeader(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := r.r.(io.Seeker); !ok || r.chunksRead {
+		return r.chunks, nil
+	}
+
+	pos := r.pos
+	off := r.dataChunk.end()
+	for r.riffSize == 0 || off+8 <= r.riffSize+8 {
+		if err := r.seekTo(off); err != nil {
+			return nil, err
+		}
+
+		chunk, err := r.nextChunk()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		r.chunks = append(r.chunks, chunk)
+		off = chunk.end()
+	}
+
+	if err := r.seekTo(pos); err != nil {
+		return nil, err
+	}
+
+	r.chunksRead = true
+
+	return r.chunks, nil
+}
+
+// nextChunk reads the chunk header at the current position.
+func (r *Reader) nextChunk() (Chunk, error) {
+	header := make([]byte, 8)
+	if err := r.readFull(header); err != nil {
+		return Chunk{}, err
+	}
+
+	chunk := Chunk{
+		ID:     string(header[:4]),
+		Offset: r.pos,
+		Size:   int64(binary.LittleEndian.Uint32(header[4:8])),
+	}
+
+	// sizes of RF64 chunks past 4 GB are in the ds64 chunk
+	if size, ok := r.ds64Sizes[chunk.ID]; ok && chunk.Size == maxRIFFSize {
+		chunk.Size = size
+	}
+
+	return chunk, nil
+}
+
+// readChunkData reads the data of a header chunk and moves past its pad byte.
+func (r *Reader) readChunkData(chunk Chunk) ([]byte, error) {
+	if chunk.Size > maxHeaderChunkSize {
+		return nil, fmt.Errorf("%q chunk too large: %d bytes", chunk.ID, chunk.Size)
+	}
+
+	data := make([]byte, chunk.Size)
+	if err := r.readFull(data); err != nil {
+		return nil, err
+	}
+
+	if err := r.seekTo(chunk.end()); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *Reader) readDS64(chunk Chunk) error {
+	// check size of ds64 header
+	if chunk.Size < 28 {
+		return fmt.Errorf("invalid ds64 header size")
+	}
 
+	data, err := r.readChunkData(chunk)
 	if err != nil {
 		return err
 	}
 
-	// validate fmt header
-	if string(fmtData[:4]) != "fmt " {
-		return fmt.Errorf("invalid fmt header")
+	// riff size (8), data size (8), sample count (8), table length (4), table
+	r.riffSize = int64(binary.LittleEndian.Uint64(data[0:8]))
+	r.ds64Sizes = map[string]int64{
+		"data": int64(binary.LittleEndian.Uint64(data[8:16])),
 	}
+	r.ds64SampleCount = int64(binary.LittleEndian.Uint64(data[16:24]))
+
+	// table of other chunks larger than 4 GB, id (4) + size (8) per entry
+	tableLength := int(binary.LittleEndian.Uint32(data[24:28]))
+	for i := 0; i < tableLength && 28+i*12+12 <= len(data); i++ {
+		entry := data[28+i*12:]
+		r.ds64Sizes[string(entry[:4])] = int64(binary.LittleEndian.Uint64(entry[4:12]))
+	}
+
+	return nil
+}
 
+func (r *Reader) readFmt(chunk Chunk) error {
 	// check size of fmt header
-	if binary.LittleEndian.Uint32(fmtData[4:8]) != 16 {
+	if chunk.Size < 16 {
 		return fmt.Errorf("invalid fmt header size")
 	}
 
+	fmtData, err := r.readChunkData(chunk)
+	if err != nil {
+		return err
+	}
+
 	// read fmt header data (values are little-endian)
-	r.AudioFormat = int(binary.LittleEndian.Uint16(fmtData[8:10]))
-	r.NumChans = int(binary.LittleEndian.Uint16(fmtData[10:12]))
-	r.SampleRate = int(binary.LittleEndian.Uint32(fmtData[12:16]))
-	r.ByteRate = int(binary.LittleEndian.Uint32(fmtData[16:20]))
-	r.BlockAlign = int(binary.LittleEndian.Uint16(fmtData[20:22]))
-	r.BitsPerSample = int(binary.LittleEndian.Uint16(fmtData[22:24]))
-
-	// read until data chunk
-	chunkHeader := make([]byte, 8)
-	for {
-		_, err = r.r.Read(chunkHeader)
-		if err != nil {
-			return err
+	r.AudioFormat = int(binary.LittleEndian.Uint16(fmtData[0:2]))
+	r.NumChans = int(binary.LittleEndian.Uint16(fmtData[2:4]))
+	r.SampleRate = int(binary.LittleEndian.Uint32(fmtData[4:8]))
+	r.ByteRate = int(binary.LittleEndian.Uint32(fmtData[8:12]))
+	r.BlockAlign = int(binary.LittleEndian.Uint16(fmtData[12:14]))
+	r.BitsPerSample = int(binary.LittleEndian.Uint16(fmtData[14:16]))
+	r.ValidBitsPerSample = r.BitsPerSample
+
+	if len(fmtData) >= 18 {
+		r.CbSize = int(binary.LittleEndian.Uint16(fmtData[16:18]))
+	}
+
+	if r.AudioFormat == FormatExtensible {
+		if len(fmtData) < 40 || r.CbSize < 22 {
+			return fmt.Errorf("invalid extensible fmt header size")
 		}
 
-		headerID := string(chunkHeader[:4])
-		headerSize := binary.LittleEndian.Uint32(chunkHeader[4:8])
+		if validBits := int(binary.LittleEndian.Uint16(fmtData[18:20])); validBits != 0 {
+			r.ValidBitsPerSample = validBits
+		}
+		r.ChannelMask = int(binary.LittleEndian.Uint32(fmtData[20:24]))
+		copy(r.SubFormat[:], fmtData[24:40])
+	}
 
-		if headerID != "data" {
-			// skip chunk
-			n, err := r.r.Read(make([]byte, int(headerSize)))
-			if err != nil {
-				return err
-			}
+	return nil
+}
 
-			if n != int(headerSize) {
-				return fmt.Errorf("data chunk not found")
-			}
+func (r *Reader) readFact(chunk Chunk) error {
+	// check size of fact header
+	if chunk.Size < 4 {
+		return fmt.Errorf("invalid fact header size")
+	}
+
+	factData, err := r.readChunkData(chunk)
+	if err != nil {
+		return err
+	}
+
+	// 0xFFFFFFFF means the real sample count is in the ds64 chunk
+	sampleLength := int64(binary.LittleEndian.Uint32(factData[0:4]))
+	if sampleLength == maxRIFFSize && r.ds64Sizes != nil {
+		sampleLength = r.ds64SampleCount
+	}
+	r.SampleLength = int(sampleLength)
+
+	return nil
+}
 
-			continue
+// readFull reads exactly len(p) bytes, a short read is io.ErrUnexpectedEOF.
+func (r *Reader) readFull(p []byte) error {
+	n, err := io.ReadFull(r.r, p)
+	r.pos += int64(n)
+	return err
+}
+
+// seekTo moves to offset off of the file. It seeks when the source is an
+// io.Seeker and otherwise discards data, which only allows moving forward.
+func (r *Reader) seekTo(off int64) error {
+	if seeker, ok := r.r.(io.Seeker); ok {
+		if _, err := seeker.Seek(off-r.pos, io.SeekCurrent); err != nil {
+			return err
 		}
+		r.pos = off
+		return nil
+	}
+
+	if off < r.pos {
+		return fmt.Errorf("cannot seek backwards in a non-seekable source")
+	}
 
-		// found data chunk!
-		r.DataSize = int(headerSize)
-		break
+	n, err := io.CopyN(io.Discard, r.r, off-r.pos)
+	r.pos += n
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
 	}
 
-	r.headerRead = true
+	return err
+}
+
+// Frames returns the number of sample frames in the data chunk.
+func (r *Reader) Frames() int64 {
+	if r.BlockAlign == 0 {
+		return 0
+	}
+	return int64(r.DataSize / r.BlockAlign)
+}
+
+// SeekFrame moves the read position to frame n of the data chunk. Moving
+// backwards requires the source to be an io.Seeker.
+func (r *Reader) SeekFrame(n int64) error {
+	if err := r.ReadHeader(); err != nil {
+		return err
+	}
+
+	if n < 0 || n > r.Frames() {
+		return fmt.Errorf("frame %d out of range [0, %d]", n, r.Frames())
+	}
+
+	off := n * int64(r.BlockAlign)
+	if err := r.seekTo(r.dataChunk.Offset + off); err != nil {
+		return err
+	}
+	r.data.N = int64(r.DataSize) - off
 
 	return nil
 }
+
+// ReadAt reads from offset off of the data chunk, it requires the source to
+// be an io.ReaderAt. Reads past the data chunk return io.EOF. Unlike Read it
+// does not move the read position and is safe to call concurrently once the
+// header is read.
+func (r *Reader) ReadAt(p []byte, off int64) (n int, err error) {
+	if !r.headerRead {
+		return 0, fmt.Errorf("header not read")
+	}
+
+	readerAt, ok := r.r.(io.ReaderAt)
+	if !ok {
+		return 0, fmt.Errorf("source does not implement io.ReaderAt")
+	}
+
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+
+	remaining := int64(r.DataSize) - off
+	if remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > remaining {
+		n, err = readerAt.ReadAt(p[:remaining], r.dataChunk.Offset+off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+
+	return readerAt.ReadAt(p, r.dataChunk.Offset+off)
+}
@@ -3,93 +3,207 @@ package wav
 import (
 	"encoding/binary"
 	"io"
+	"sync"
 	"sync/atomic"
 )
 
+// largest size that fits in the 32-bit RIFF size fields
+const maxRIFFSize = 0xFFFFFFFF
+
 type Writer struct {
 	w             io.WriterAt
-	headerWritten bool
+	header        []byte
+	factOffset    int64
+	dataOffset    int64
+	headerOnce    sync.Once
+	headerErr     error
 	audioFormat   int
 	numChans      int
 	sampleRate    int
 	bitsPerSample int
+	extensible    bool
+	channelMask   int
+
+	// encoder and frame buffers of WriteFrames
+	encodeOnce sync.Once
+	encode     EncodeFunc
+	encodeErr  error
+	frameBufs  sync.Pool
 
-	dataSize *atomic.Uint32
+	dataSize       *atomic.Uint64
+	trailingChunks []byte
 }
 
 func NewWriter(w io.WriterAt, audioFormat int, numChans int, sampleRate int, bitsPerSample int) *Writer {
-	return &Writer{
+	writer := &Writer{
 		w:             w,
 		audioFormat:   audioFormat,
 		numChans:      numChans,
 		sampleRate:    sampleRate,
 		bitsPerSample: bitsPerSample,
-		dataSize:      &atomic.Uint32{},
+		dataSize:      &atomic.Uint64{},
 	}
+	writer.buildHeader()
+
+	return writer
 }
 
+// NewExtensibleWriter returns a writer with a WAVE_FORMAT_EXTENSIBLE fmt
+// chunk holding audioFormat as its SubFormat and the speaker positions of
+// channelMask, 0 for channels without positions (e.g. ambisonics).
+func NewExtensibleWriter(w io.WriterAt, audioFormat int, numChans int, sampleRate int, bitsPerSample int, channelMask int) *Writer {
+	writer := &Writer{
+		w:             w,
+		audioFormat:   audioFormat,
+		numChans:      numChans,
+		sampleRate:    sampleRate,
+		bitsPerSample: bitsPerSample,
+		extensible:    true,
+		channelMask:   channelMask,
+		dataSize:      &atomic.Uint64{},
+	}
+	writer.buildHeader()
+
+	return writer
+}
+
+// WriteAt writes PCM data at offset off of the data chunk. It is safe to call
+// concurrently for non-overlapping ranges.
 func (w *Writer) WriteAt(p []byte, off int64) (n int, err error) {
-	if !w.headerWritten {
-		err := w.writeHeader()
-		if err != nil {
-			return 0, err
-		}
+	if err := w.writeHeader(); err != nil {
+		return 0, err
 	}
 
-	n, err = w.w.WriteAt(p, off+44)
+	n, err = w.w.WriteAt(p, off+w.dataOffset)
 
 	if err != nil {
 		return 0, err
 	}
 
-	w.dataSize.Add(uint32(n))
+	w.growDataSize(uint64(off) + uint64(n))
 
 	return n, nil
 }
 
-func (w *Writer) writeHeader() error {
-	header := make([]byte, 44)
+// growDataSize raises the data size to end if it is past the current end.
+func (w *Writer) growDataSize(end uint64) {
+	for {
+		size := w.dataSize.Load()
+		if end <= size || w.dataSize.CompareAndSwap(size, end) {
+			return
+		}
+	}
+}
+
+// Size returns the size of the file once closed, with the data written so far
+// and the chunks added after it.
+func (w *Writer) Size() int64 {
+	dataSize := int64(w.dataSize.Load())
+	return w.dataOffset + dataSize + dataSize%2 + int64(len(w.trailingChunks))
+}
+
+// buildHeader lays out the header chunks, the data offset depends on the format.
+func (w *Writer) buildHeader() {
+	le := binary.LittleEndian
 
 	// RIFF header
-	copy(header[0:], "RIFF")
-	binary.LittleEndian.PutUint32(header[4:], 0)
-	copy(header[8:], "WAVE")
+	header := []byte("RIFF\x00\x00\x00\x00WAVE")
+
+	// JUNK chunk, reserves room for a ds64 chunk if the file grows past 4 GB
+	header = append(header, "JUNK"...)
+	header = le.AppendUint32(header, 28)
+	header = append(header, make([]byte, 28)...)
 
-	// fmt header
-	copy(header[12:], "fmt ")
-	binary.LittleEndian.PutUint32(header[16:], 16)
-	binary.LittleEndian.PutUint16(header[20:], 1)
-	binary.LittleEndian.PutUint16(header[22:], uint16(w.numChans))
-	binary.LittleEndian.PutUint32(header[24:], uint32(w.sampleRate))
+	// fmt header, formats other than PCM carry a cbSize
+	fmtSize := 16
+	formatTag := w.audioFormat
+	if w.extensible {
+		fmtSize = 40
+		formatTag = FormatExtensible
+	} else if w.audioFormat != FormatPCM {
+		fmtSize = 18
+	}
+	header = append(header, "fmt "...)
+	header = le.AppendUint32(header, uint32(fmtSize))
+	header = le.AppendUint16(header, uint16(formatTag))
+	header = le.AppendUint16(header, uint16(w.numChans))
+	header = le.AppendUint32(header, uint32(w.sampleRate))
 	byteRate := w.sampleRate * w.numChans * w.bitsPerSample / 8
-	binary.LittleEndian.PutUint32(header[28:], uint32(byteRate))
+	header = le.AppendUint32(header, uint32(byteRate))
 	blockAlign := w.numChans * w.bitsPerSample / 8
-	binary.LittleEndian.PutUint16(header[32:], uint16(blockAlign))
-	binary.LittleEndian.PutUint16(header[34:], uint16(w.bitsPerSample))
+	header = le.AppendUint16(header, uint16(blockAlign))
+	header = le.AppendUint16(header, uint16(w.bitsPerSample))
+	if fmtSize == 18 {
+		header = le.AppendUint16(header, 0)
+	}
+	if w.extensible {
+		header = le.AppendUint16(header, 22)
+		header = le.AppendUint16(header, uint16(w.bitsPerSample))
+		header = le.AppendUint32(header, uint32(w.channelMask))
+		guid := subFormatGUID(w.audioFormat)
+		header = append(header, guid[:]...)
+	}
+
+	// fact header, required for formats other than PCM
+	if w.audioFormat != FormatPCM {
+		header = append(header, "fact"...)
+		header = le.AppendUint32(header, 4)
+		w.factOffset = int64(len(header))
+		header = le.AppendUint32(header, 0)
+	}
 
 	// data header
-	copy(header[36:], "data")
-	binary.LittleEndian.PutUint32(header[40:], 0)
+	header = append(header, "data"...)
+	header = le.AppendUint32(header, 0)
 
-	_, err := w.w.WriteAt(header, 0)
-	if err != nil {
-		return err
-	}
+	w.header = header
+	w.dataOffset = int64(len(header))
+}
 
-	return nil
+func (w *Writer) writeHeader() error {
+	w.headerOnce.Do(func() {
+		_, w.headerErr = w.w.WriteAt(w.header, 0)
+	})
+
+	return w.headerErr
 }
 
 func (w *Writer) Close() error {
-	if !w.headerWritten {
-		err := w.writeHeader()
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	dataSize := w.dataSize.Load()
+
+	// chunks are word aligned, odd sized data gets a pad byte
+	padSize := dataSize % 2
+	if padSize == 1 || len(w.trailingChunks) > 0 {
+		trailer := append(make([]byte, padSize), w.trailingChunks...)
+		_, err := w.w.WriteAt(trailer, w.dataOffset+int64(dataSize))
 		if err != nil {
 			return err
 		}
 	}
 
+	// update fact header with the number of sample frames
+	if w.factOffset != 0 {
+		sampleLength := min(dataSize/w.blockAlign(), maxRIFFSize)
+		sampleLengthBytes := make([]byte, 4)
+		binary.LittleEndian.PutUint32(sampleLengthBytes, uint32(sampleLength))
+		_, err := w.w.WriteAt(sampleLengthBytes, w.factOffset)
+		if err != nil {
+			return err
+		}
+	}
+
+	riffSize := uint64(w.dataOffset) - 8 + dataSize + padSize + uint64(len(w.trailingChunks))
+	if riffSize > maxRIFFSize {
+		return w.writeRF64Sizes(riffSize, dataSize)
+	}
+
 	// update RIFF header with file size
 	fileSizeBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(fileSizeBytes, w.dataSize.Load()+36)
+	binary.LittleEndian.PutUint32(fileSizeBytes, uint32(riffSize))
 	_, err := w.w.WriteAt(fileSizeBytes, 4)
 	if err != nil {
 		return err
@@ -97,11 +211,47 @@ func (w *Writer) Close() error {
 
 	// update data header with data size
 	dataSizeBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(dataSizeBytes, w.dataSize.Load())
-	_, err = w.w.WriteAt(dataSizeBytes, 40)
+	binary.LittleEndian.PutUint32(dataSizeBytes, uint32(dataSize))
+	_, err = w.w.WriteAt(dataSizeBytes, w.dataOffset-4)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// writeRF64Sizes turns the file into an RF64 file by replacing the JUNK chunk
+// with a ds64 chunk holding the 64-bit sizes.
+func (w *Writer) writeRF64Sizes(riffSize, dataSize uint64) error {
+	header := make([]byte, 48)
+
+	copy(header[0:], "RF64")
+	binary.LittleEndian.PutUint32(header[4:], maxRIFFSize)
+	copy(header[8:], "WAVE")
+
+	copy(header[12:], "ds64")
+	binary.LittleEndian.PutUint32(header[16:], 28)
+	binary.LittleEndian.PutUint64(header[20:], riffSize)
+	binary.LittleEndian.PutUint64(header[28:], dataSize)
+	binary.LittleEndian.PutUint64(header[36:], dataSize/w.blockAlign())
+	binary.LittleEndian.PutUint32(header[44:], 0)
+
+	_, err := w.w.WriteAt(header, 0)
+	if err != nil {
+		return err
+	}
+
+	// data header size is read from ds64
+	dataSizeBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(dataSizeBytes, maxRIFFSize)
+	_, err = w.w.WriteAt(dataSizeBytes, w.dataOffset-4)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (w *Writer) blockAlign() uint64 {
+	return uint64(w.numChans * w.bitsPerSample / 8)
+}
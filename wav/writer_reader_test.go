@@ -3,9 +3,14 @@ package wav
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestWriterThenReader(t *testing.T) {
@@ -102,3 +107,591 @@ func TestWriterThenReader(t *testing.T) {
 		}
 	}
 }
+
+func TestWriterSwitchesToRF64(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "large.wav")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(file, 1, 1, 96000, 32)
+
+	// sparse write just past the 32-bit limit
+	dataSize := int64(1<<32) + 4
+	_, err = w.WriteAt([]byte{1, 2, 3, 4}, dataSize-4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	file, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	riffID := make([]byte, 4)
+	if _, err = file.ReadAt(riffID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if string(riffID) != "RF64" {
+		t.Fatal("RIFF ID is not RF64", string(riffID))
+	}
+
+	wav := NewReader(file)
+	if err = wav.ReadHeader(); err != nil {
+		t.Fatal(err)
+	}
+
+	if int64(wav.DataSize) != dataSize {
+		t.Fatal("DataSize is incorrect", wav.DataSize)
+	}
+
+	if wav.NumChans != 1 || wav.SampleRate != 96000 || wav.BitsPerSample != 32 {
+		t.Fatal("fmt chunk is incorrect")
+	}
+}
+
+// buildChunk returns a chunk with its header and pad byte.
+func buildChunk(id string, data []byte) []byte {
+	chunk := make([]byte, 8, 8+len(data)+1)
+	copy(chunk, id)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// buildWav returns a RIFF WAVE file holding the given chunks.
+func buildWav(chunks ...[]byte) []byte {
+	body := []byte("WAVE")
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+	return buildChunk("RIFF", body)
+}
+
+func TestReaderExtensibleFmt(t *testing.T) {
+	fmtData := make([]byte, 40)
+	binary.LittleEndian.PutUint16(fmtData[0:], FormatExtensible)
+	binary.LittleEndian.PutUint16(fmtData[2:], 4)
+	binary.LittleEndian.PutUint32(fmtData[4:], 48000)
+	binary.LittleEndian.PutUint32(fmtData[8:], 48000*4*3)
+	binary.LittleEndian.PutUint16(fmtData[12:], 4*3)
+	binary.LittleEndian.PutUint16(fmtData[14:], 24)
+	binary.LittleEndian.PutUint16(fmtData[16:], 22)
+	binary.LittleEndian.PutUint16(fmtData[18:], 20)
+	binary.LittleEndian.PutUint32(fmtData[20:], 0x33)
+	guid := subFormatGUID(FormatIEEEFloat)
+	copy(fmtData[24:], guid[:])
+
+	wav := NewReader(bytes.NewReader(buildWav(
+		buildChunk("fmt ", fmtData),
+		buildChunk("data", make([]byte, 4*3*10)),
+	)))
+	if err := wav.ReadHeader(); err != nil {
+		t.Fatal(err)
+	}
+
+	if wav.AudioFormat != FormatExtensible {
+		t.Fatal("AudioFormat is not extensible")
+	}
+
+	if wav.SampleFormat() != FormatIEEEFloat {
+		t.Fatal("SampleFormat is not IEEE float", wav.SampleFormat())
+	}
+
+	if wav.CbSize != 22 || wav.ValidBitsPerSample != 20 || wav.ChannelMask != 0x33 {
+		t.Fatal("extensible fields are incorrect", wav.CbSize, wav.ValidBitsPerSample, wav.ChannelMask)
+	}
+
+	if wav.BitsPerSample != 24 || wav.NumChans != 4 || wav.DataSize != 4*3*10 {
+		t.Fatal("fmt chunk is incorrect")
+	}
+}
+
+// nonSeeker hides the io.Seeker of a reader.
+type nonSeeker struct {
+	io.Reader
+}
+
+func TestReaderChunks(t *testing.T) {
+	fmtData := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtData[0:], FormatPCM)
+	binary.LittleEndian.PutUint16(fmtData[2:], 1)
+	binary.LittleEndian.PutUint32(fmtData[4:], 48000)
+	binary.LittleEndian.PutUint32(fmtData[8:], 48000*2)
+	binary.LittleEndian.PutUint16(fmtData[12:], 2)
+	binary.LittleEndian.PutUint16(fmtData[14:], 16)
+
+	file := buildWav(
+		buildChunk("LIST", []byte("odd")),
+		buildChunk("fmt ", fmtData),
+		buildChunk("bext", make([]byte, 101)),
+		buildChunk("data", []byte{1, 2, 3, 4}),
+		buildChunk("id3 ", []byte("tag")),
+	)
+
+	expected := []Chunk{
+		{"LIST", 20, 3},
+		{"fmt ", 32, 16},
+		{"bext", 56, 101},
+		{"data", 166, 4},
+		{"id3 ", 178, 3},
+	}
+
+	for _, seekable := range []bool{true, false} {
+		var src io.Reader = bytes.NewReader(file)
+		if !seekable {
+			src = nonSeeker{src}
+		}
+
+		wav := NewReader(src)
+		chunks, err := wav.Chunks()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// chunks after data are only found when seeking
+		want := expected
+		if !seekable {
+			want = expected[:4]
+		}
+
+		if len(chunks) != len(want) {
+			t.Fatal("wrong number of chunks", seekable, chunks)
+		}
+		for i := range want {
+			if chunks[i] != want[i] {
+				t.Fatal("chunk is incorrect", seekable, chunks[i], want[i])
+			}
+		}
+
+		data := make([]byte, 4)
+		if _, err = io.ReadFull(wav, data); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, []byte{1, 2, 3, 4}) {
+			t.Fatal("data is incorrect", seekable, data)
+		}
+	}
+}
+
+func TestReaderStopsAtEndOfData(t *testing.T) {
+	fmtData := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtData[0:], FormatPCM)
+	binary.LittleEndian.PutUint16(fmtData[2:], 2)
+	binary.LittleEndian.PutUint32(fmtData[4:], 44100)
+	binary.LittleEndian.PutUint32(fmtData[8:], 44100*4)
+	binary.LittleEndian.PutUint16(fmtData[12:], 4)
+	binary.LittleEndian.PutUint16(fmtData[14:], 16)
+
+	data := make([]byte, 4*1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	wav := NewReader(bytes.NewReader(buildWav(
+		buildChunk("fmt ", fmtData),
+		buildChunk("data", data),
+		buildChunk("LIST", []byte("INFOINAM\x06\x00\x00\x00trailer\x00")),
+	)))
+
+	// read in chunks that don't line up with the end of the data
+	read := &bytes.Buffer{}
+	buf := make([]byte, 3000)
+	for {
+		n, err := wav.Read(buf)
+		read.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(read.Bytes(), data) {
+		t.Fatal("read data is incorrect, length", read.Len())
+	}
+}
+
+func TestWriterThenReaderFloat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "float.wav")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(file, FormatIEEEFloat, 2, 48000, 32)
+
+	data := &bytes.Buffer{}
+	for i := 0; i < 1000; i++ {
+		binary.Write(data, binary.LittleEndian, float32(i)/1000)
+		binary.Write(data, binary.LittleEndian, -float32(i)/1000)
+	}
+
+	if _, err = w.WriteAt(data.Bytes(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	file, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	wav := NewReader(file)
+	if err = wav.ReadHeader(); err != nil {
+		t.Fatal(err)
+	}
+
+	if wav.AudioFormat != FormatIEEEFloat || wav.SampleFormat() != FormatIEEEFloat {
+		t.Fatal("AudioFormat is not IEEE float", wav.AudioFormat)
+	}
+
+	if wav.BitsPerSample != 32 || wav.BlockAlign != 8 {
+		t.Fatal("fmt chunk is incorrect")
+	}
+
+	if wav.SampleLength != 1000 {
+		t.Fatal("fact sample length is incorrect", wav.SampleLength)
+	}
+
+	read, err := io.ReadAll(wav)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data.Bytes()) {
+		t.Fatal("read data is incorrect")
+	}
+}
+
+func TestExtensibleWriterThenReader(t *testing.T) {
+	for _, format := range []int{FormatPCM, FormatIEEEFloat} {
+		path := filepath.Join(t.TempDir(), "extensible.wav")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mask := SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter | SpeakerLowFrequency | SpeakerBackLeft | SpeakerBackRight
+		w := NewExtensibleWriter(file, format, 6, 48000, 32, mask)
+
+		data := make([]byte, 6*4*100)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		if _, err = w.WriteAt(data, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		file, err = os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wav := NewReader(file)
+		if err = wav.ReadHeader(); err != nil {
+			t.Fatal(err)
+		}
+
+		if wav.AudioFormat != FormatExtensible || wav.SampleFormat() != format {
+			t.Fatal("format is incorrect", wav.AudioFormat, wav.SampleFormat())
+		}
+
+		if wav.CbSize != 22 || wav.ValidBitsPerSample != 32 || wav.ChannelMask != 0x3F {
+			t.Fatal("extensible fields are incorrect", wav.CbSize, wav.ValidBitsPerSample, wav.ChannelMask)
+		}
+
+		if wav.NumChans != 6 || wav.BlockAlign != 24 || wav.DataSize != len(data) {
+			t.Fatal("fmt chunk is incorrect")
+		}
+
+		read, err := io.ReadAll(wav)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(read, data) {
+			t.Fatal("read data is incorrect")
+		}
+		file.Close()
+	}
+}
+
+func TestWriteFramesThenReadFrames(t *testing.T) {
+	formats := []struct {
+		format int
+		bits   int
+	}{
+		{FormatPCM, 8},
+		{FormatPCM, 16},
+		{FormatPCM, 24},
+		{FormatPCM, 32},
+		{FormatIEEEFloat, 32},
+		{FormatIEEEFloat, 64},
+	}
+
+	frames := [][]float64{
+		{0, 0.5, -0.5, -1, 0.25, 0.999},
+		{0.1, -0.1, 0.75, -0.75, 0, -0.25},
+	}
+
+	for _, f := range formats {
+		path := filepath.Join(t.TempDir(), "frames.wav")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewWriter(file, f.format, 2, 48000, f.bits)
+
+		// write in two parts to check frame offsets
+		if _, err = w.WriteFrames([][]float64{frames[0][:2], frames[1][:2]}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.WriteFrames([][]float64{frames[0][2:], frames[1][2:]}, 2); err != nil {
+			t.Fatal(err)
+		}
+		size := w.Size()
+		w.Close()
+		file.Close()
+
+		if info, err := os.Stat(path); err != nil || info.Size() != size {
+			t.Fatal("Size is incorrect", f, size, info, err)
+		}
+
+		file, err = os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wav := NewReader(file)
+
+		read := [][]float64{make([]float64, 10), make([]float64, 10)}
+		n, err := wav.ReadFrames(read)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(frames[0]) {
+			t.Fatal("wrong number of frames", f, n)
+		}
+
+		tolerance := 1.0 / float64(int64(1)<<(f.bits-1))
+		if f.format == FormatIEEEFloat {
+			tolerance = 1e-7
+		}
+		for ch := range frames {
+			for i, v := range frames[ch] {
+				if diff := read[ch][i] - v; diff > tolerance || diff < -tolerance {
+					t.Fatal("sample is incorrect", f, ch, i, read[ch][i], v)
+				}
+			}
+		}
+
+		if _, err = wav.ReadFrames(read); err != io.EOF {
+			t.Fatal("expected io.EOF", f, err)
+		}
+		file.Close()
+
+		// int32 frames are full scale
+		file, err = os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wav = NewReader(file)
+
+		ints := [][]int32{make([]int32, 10), make([]int32, 10)}
+		if _, err = wav.ReadInt32Frames(ints); err != nil {
+			t.Fatal(err)
+		}
+		if ints[0][1] != 1<<30 || ints[0][3] != -1<<31 {
+			t.Fatal("int32 sample is incorrect", f, ints[0][1], ints[0][3])
+		}
+		file.Close()
+	}
+}
+
+func TestReadFramesError(t *testing.T) {
+	fmtData := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtData[0:], FormatPCM)
+	binary.LittleEndian.PutUint16(fmtData[2:], 2)
+	binary.LittleEndian.PutUint32(fmtData[4:], 48000)
+	binary.LittleEndian.PutUint32(fmtData[8:], 48000*4)
+	binary.LittleEndian.PutUint16(fmtData[12:], 4)
+	binary.LittleEndian.PutUint16(fmtData[14:], 16)
+
+	file := buildWav(buildChunk("fmt ", fmtData), buildChunk("data", make([]byte, 4*100)))
+	frames := [][]float64{make([]float64, 100), make([]float64, 100)}
+
+	// a read error after 10 and a half frames is returned with the frames
+	broken := errors.New("broken")
+	header := len(file) - 4*100
+	wav := NewReader(io.MultiReader(bytes.NewReader(file[:header+42]), iotest.ErrReader(broken)))
+	if n, err := wav.ReadFrames(frames); n != 10 || err != broken {
+		t.Fatal("expected 10 frames and the read error", n, err)
+	}
+
+	// a partial frame at the end of the data is dropped
+	file = buildWav(buildChunk("fmt ", fmtData), buildChunk("data", make([]byte, 4*10+2)))
+	wav = NewReader(bytes.NewReader(file))
+	if n, err := wav.ReadFrames(frames); n != 10 || err != nil {
+		t.Fatal("expected 10 frames", n, err)
+	}
+	if n, err := wav.ReadFrames(frames); n != 0 || err != io.EOF {
+		t.Fatal("expected io.EOF", n, err)
+	}
+}
+
+func TestReaderRandomAccess(t *testing.T) {
+	fmtData := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtData[0:], FormatPCM)
+	binary.LittleEndian.PutUint16(fmtData[2:], 1)
+	binary.LittleEndian.PutUint32(fmtData[4:], 48000)
+	binary.LittleEndian.PutUint32(fmtData[8:], 48000*2)
+	binary.LittleEndian.PutUint16(fmtData[12:], 2)
+	binary.LittleEndian.PutUint16(fmtData[14:], 16)
+
+	// frame i holds sample value i
+	data := &bytes.Buffer{}
+	for i := 0; i < 100; i++ {
+		binary.Write(data, binary.LittleEndian, int16(i))
+	}
+
+	wav := NewReader(bytes.NewReader(buildWav(
+		buildChunk("fmt ", fmtData),
+		buildChunk("data", data.Bytes()),
+		buildChunk("LIST", make([]byte, 20)),
+	)))
+
+	frames := [][]float64{make([]float64, 30)}
+	if _, err := wav.ReadFramesAt(frames, 0); err == nil || !strings.Contains(err.Error(), "header not read") {
+		t.Fatal("expected header not read error", err)
+	}
+
+	if err := wav.ReadHeader(); err != nil {
+		t.Fatal(err)
+	}
+
+	if wav.Frames() != 100 {
+		t.Fatal("Frames is incorrect", wav.Frames())
+	}
+
+	for _, seek := range []int64{60, 10, 90} {
+		if err := wav.SeekFrame(seek); err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := wav.ReadFrames(frames)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// reads stay bounded to the data chunk after seeking
+		if n != int(min(30, 100-seek)) {
+			t.Fatal("wrong number of frames", seek, n)
+		}
+
+		if frames[0][0]*(1<<15) != float64(seek) {
+			t.Fatal("frame is incorrect", seek, frames[0][0]*(1<<15))
+		}
+	}
+
+	if err := wav.SeekFrame(101); err == nil {
+		t.Fatal("expected out of range error")
+	}
+
+	n, err := wav.ReadFramesAt(frames, 95)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 || frames[0][4]*(1<<15) != 99 {
+		t.Fatal("ReadFramesAt is incorrect", n)
+	}
+
+	if _, err = wav.ReadFramesAt(frames, 100); err != io.EOF {
+		t.Fatal("expected io.EOF", err)
+	}
+}
+
+func TestWriterCuePoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cues.wav")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(file, FormatPCM, 1, 48000, 16)
+
+	// odd data size so the trailing chunks follow a pad byte
+	if _, err = w.WriteAt(make([]byte, 1001), 0); err != nil {
+		t.Fatal(err)
+	}
+	w.SetCuePoints([]CuePoint{
+		{ID: 1, Position: 10, Label: "Intro"},
+		{ID: 2, Position: 400},
+	})
+	size := w.Size()
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != size {
+		t.Fatal("Size is incorrect", size, info.Size())
+	}
+
+	file, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	chunks, err := NewReader(file).Chunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := ""
+	for _, chunk := range chunks {
+		ids += chunk.ID + ","
+	}
+	if ids != "JUNK,fmt ,data,cue ,LIST," {
+		t.Fatal("chunks are incorrect", ids)
+	}
+
+	cue := chunks[3]
+	if cue.Size != 4+2*24 {
+		t.Fatal("cue chunk size is incorrect", cue.Size)
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	cues, err := NewReader(file).CuePoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []CuePoint{
+		{ID: 1, Position: 10, Label: "Intro"},
+		{ID: 2, Position: 400},
+	}
+	if len(cues) != len(expected) || cues[0] != expected[0] || cues[1] != expected[1] {
+		t.Fatal("cue points are incorrect", cues)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SE_LOG.BIN layout, all values are little-endian uint32
+const (
+	xliveLogName       = "SE_LOG.BIN"
+	xliveLogSize       = 2048
+	xliveMaxTakes      = 256
+	xliveMaxMarkers    = 125
+	xliveTakesOffset   = 28
+	xliveMarkersOffset = xliveTakesOffset + xliveMaxTakes*4
+	xliveNameOffset    = xliveMarkersOffset + xliveMaxMarkers*4
+	xliveNameSize      = 20
+)
+
+// XLiveSession is the session log X32/M32 X-LIVE cards write next to the WAV
+// files of a recording.
+type XLiveSession struct {
+	Path       string
+	Name       string
+	Channels   int
+	SampleRate int
+	FileCount  int
+	Takes      []int64  // frames of each file
+	Markers    []Marker // frames from the start of the session
+}
+
+// findXLiveSession reads the session log in the input folder, or next to the
+// input file. It returns nil if there is none.
+func findXLiveSession(input string) (*XLiveSession, error) {
+	dir := input
+	if info, err := os.Stat(input); err == nil && !info.IsDir() {
+		dir = filepath.Dir(input)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if strings.EqualFold(entry.Name(), xliveLogName) && !entry.IsDir() {
+			return readXLiveSession(filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return nil, nil
+}
+
+func readXLiveSession(path string) (*XLiveSession, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < xliveLogSize {
+		return nil, fmt.Errorf("invalid session log %s: %d bytes, expected %d", path, len(data), xliveLogSize)
+	}
+
+	// session id (0), channels (4), sample rate (8), date (12), takes (16),
+	// markers (20), total length (24), take sizes, marker positions, name
+	u32 := func(off int) int {
+		return int(binary.LittleEndian.Uint32(data[off:]))
+	}
+
+	session := &XLiveSession{
+		Path:       path,
+		Channels:   u32(4),
+		SampleRate: u32(8),
+		FileCount:  u32(16),
+	}
+
+	if session.FileCount > xliveMaxTakes {
+		return nil, fmt.Errorf("invalid session log %s: %d files", path, session.FileCount)
+	}
+
+	for i := 0; i < session.FileCount; i++ {
+		session.Takes = append(session.Takes, int64(u32(xliveTakesOffset+i*4)))
+	}
+
+	markerCount := min(u32(20), xliveMaxMarkers)
+	for i := 0; i < markerCount; i++ {
+		session.Markers = append(session.Markers, Marker{
+			Position: int64(u32(xliveMarkersOffset + i*4)),
+			Label:    fmt.Sprintf("Marker %d", i+1),
+		})
+	}
+
+	name := data[xliveNameOffset : xliveNameOffset+xliveNameSize]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	session.Name = strings.TrimSpace(string(name))
+
+	return session, nil
+}
+
+// checkFiles checks the session log belongs to the wav files. A log of
+// another recording, or laid out differently than expected, would give
+// markers at the wrong positions.
+func (s *XLiveSession) checkFiles(wavFiles []*WavFile) error {
+	wavFile := wavFiles[0]
+	if s.Channels != wavFile.NumChans || s.SampleRate != wavFile.SampleRate {
+		return fmt.Errorf("session log %s is for %d channels at %d Hz but the files have %d channels at %d Hz", s.Path, s.Channels, s.SampleRate, wavFile.NumChans, wavFile.SampleRate)
+	}
+	return nil
+}
+
+// writeXLiveSession writes a session log for takes of the given frames made at
+// t and returns the session id.
+func writeXLiveSession(path string, session *XLiveSession, takes []int64, t time.Time) (uint32, error) {
+	if len(takes) > xliveMaxTakes {
+		return 0, fmt.Errorf("%d files are more than the %d of a session", len(takes), xliveMaxTakes)
+	}
+
+	data := make([]byte, xliveLogSize)
+	putU32 := func(off int, v uint32) {
+		binary.LittleEndian.PutUint32(data[off:], v)
+	}
+
+	// the id is the FAT date & time of the session
+	id := uint32(t.Year()-1980)<<25 | uint32(t.Month())<<21 | uint32(t.Day())<<16 |
+		uint32(t.Hour())<<11 | uint32(t.Minute())<<5 | uint32(t.Second()/2)
+
+	total := int64(0)
+	for i, frames := range takes {
+		putU32(xliveTakesOffset+i*4, uint32(frames))
+		total += frames
+	}
+	if total > math.MaxUint32 {
+		return 0, fmt.Errorf("%d frames are more than a session log can hold", total)
+	}
+
+	putU32(0, id)
+	putU32(4, uint32(session.Channels))
+	putU32(8, uint32(session.SampleRate))
+	putU32(12, id)
+	putU32(16, uint32(len(takes)))
+	putU32(20, uint32(min(len(session.Markers), xliveMaxMarkers)))
+	putU32(24, uint32(total))
+
+	for i, marker := range session.Markers[:min(len(session.Markers), xliveMaxMarkers)] {
+		putU32(xliveMarkersOffset+i*4, uint32(marker.Position))
+	}
+
+	// the name is zero terminated
+	name := session.Name
+	if len(name) >= xliveNameSize {
+		name = name[:xliveNameSize-1]
+	}
+	copy(data[xliveNameOffset:], name)
+
+	return id, os.WriteFile(path, data, 0644)
+}
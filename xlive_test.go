@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/calebmcelroy/wav-extract/wav"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testdata/xlive/SE_LOG.BIN is the session log of a 32 channel 48 kHz
+// recording of three files with three markers
+func TestReadXLiveSession(t *testing.T) {
+	session, err := findXLiveSession(filepath.Join("testdata", "xlive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session == nil {
+		t.Fatal("session log not found")
+	}
+
+	if session.Name != "Sunday Service" || session.Channels != 32 || session.SampleRate != 48000 || session.FileCount != 3 {
+		t.Errorf("session = %q, %d channels, %d Hz, %d files", session.Name, session.Channels, session.SampleRate, session.FileCount)
+	}
+
+	if want := []int64{33554431, 33554431, 12000000}; !reflect.DeepEqual(session.Takes, want) {
+		t.Errorf("takes = %v, want %v", session.Takes, want)
+	}
+
+	want := []Marker{{480000, "Marker 1"}, {34000000, "Marker 2"}, {70000000, "Marker 3"}}
+	if !reflect.DeepEqual(session.Markers, want) {
+		t.Errorf("markers = %v, want %v", session.Markers, want)
+	}
+
+	// a folder without a session log
+	if session, err := findXLiveSession(t.TempDir()); err != nil || session != nil {
+		t.Errorf("findXLiveSession of an empty folder = %v, %v", session, err)
+	}
+
+	// logs of the wrong size are rejected
+	path := filepath.Join(t.TempDir(), xliveLogName)
+	if err := os.WriteFile(path, make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readXLiveSession(path); err == nil {
+		t.Error("readXLiveSession of a short file succeeded")
+	}
+}
+
+func TestXLiveSessionCheckFiles(t *testing.T) {
+	session, err := readXLiveSession(filepath.Join("testdata", "xlive", xliveLogName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wavFile := func(numChans, sampleRate int) []*WavFile {
+		return []*WavFile{{Reader: &wav.Reader{NumChans: numChans, SampleRate: sampleRate}, Name: "00000001.WAV"}}
+	}
+
+	if err := session.checkFiles(wavFile(32, 48000)); err != nil {
+		t.Error(err)
+	}
+	if err := session.checkFiles(wavFile(16, 48000)); err == nil {
+		t.Error("checkFiles with another channel count succeeded")
+	}
+	if err := session.checkFiles(wavFile(32, 44100)); err == nil {
+		t.Error("checkFiles with another sample rate succeeded")
+	}
+}